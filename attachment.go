@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// GetEBSVolumeIDByAttachment finds the EBS volume attached to the current
+// instance as the given block device. It's used on Xen-based instances,
+// where xvd* devices don't expose serial numbers.
+func GetEBSVolumeIDByAttachment(blockDevice string) (string, error) {
+	instanceID, err := newMetadataClient().GetMetadata("instance-id")
+	if err != nil {
+		return "", fmt.Errorf("Couldn't get the instance ID from the instance metadata: %s", err)
+	}
+	log.Debugln("Current instance ID:", instanceID)
+
+	volumesFilters := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("attachment.instance-id"),
+				Values: []*string{aws.String(instanceID)},
+			},
+		},
+	}
+
+	svc, err := newEC2Client()
+	if err != nil {
+		return "", err
+	}
+
+	var volumes []*ec2.Volume
+	err = svc.DescribeVolumesPages(volumesFilters, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return matchVolumeByAttachment(volumes, instanceID, blockDevice)
+}
+
+// matchVolumeByAttachment returns the ID of the volume attached to the
+// instance under the name of the block device.
+func matchVolumeByAttachment(volumes []*ec2.Volume, instanceID, blockDevice string) (string, error) {
+	blockDeviceName := normalizeAttachmentDeviceName(blockDevice)
+
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) != instanceID {
+				continue
+			}
+
+			log.Debugf("Volume %s is attached as \"%s\".", aws.StringValue(volume.VolumeId), aws.StringValue(attachment.Device))
+			if normalizeAttachmentDeviceName(aws.StringValue(attachment.Device)) == blockDeviceName {
+				return aws.StringValue(volume.VolumeId), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("No EBS volume is attached to the instance %s as \"%s\"", instanceID, blockDevice)
+}
+
+// normalizeAttachmentDeviceName brings block device names and EBS attachment
+// device names to the same form. The kernel renames /dev/sd* devices from
+// the block device mapping to /dev/xvd*, and the root device is often mapped
+// as a partition, e.g. /dev/sda1 is the xvda disk.
+func normalizeAttachmentDeviceName(name string) string {
+	name = strings.TrimPrefix(name, "/dev/")
+
+	if strings.HasPrefix(name, "sd") {
+		name = "xvd" + strings.TrimPrefix(name, "sd")
+	}
+
+	return strings.TrimRight(name, "0123456789")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// newEC2Client creates the EC2 API client. The shared config file is read
+// like the credentials file, so profiles set the region and assumed roles.
+// If the region isn't configured in the environment or the profile, it's
+// taken from the instance metadata. The endpoint can be overridden with the
+// ec2-endpoint flag, e.g. to use a local EC2 stand-in.
+func newEC2Client() (*ec2.EC2, error) {
+	awsSession, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create the AWS session: %s", err)
+	}
+	config := aws.NewConfig()
+
+	if aws.StringValue(awsSession.Config.Region) == "" {
+		region, err := newMetadataClient().Region()
+		if err != nil {
+			log.Debugln("Couldn't get the region from the instance metadata:", err)
+		} else {
+			log.Debugln("Region from the instance metadata:", region)
+			config = config.WithRegion(region)
+		}
+	}
+
+	if *ec2Endpoint != "" {
+		config = config.WithEndpoint(*ec2Endpoint)
+	}
+
+	return ec2.New(awsSession, config), nil
+}
+
+// describeVolumesModifications calls the function for every modification of
+// the EBS volumes. The volume-id filter doesn't fail for volumes which were
+// never modified, unlike the VolumeIds parameter.
+func describeVolumesModifications(svc *ec2.EC2, volumeIDsList []string, fn func(modification *ec2.VolumeModification)) error {
+	return svc.DescribeVolumesModificationsPages(&ec2.DescribeVolumesModificationsInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("volume-id"),
+			Values: aws.StringSlice(volumeIDsList),
+		}},
+	}, func(page *ec2.DescribeVolumesModificationsOutput, lastPage bool) bool {
+		for _, modification := range page.VolumesModifications {
+			fn(modification)
+		}
+		return true
+	})
+}
+
+// newMetadataClient creates the EC2 instance metadata (IMDS) client. The
+// endpoint can be overridden with the imds-endpoint flag.
+func newMetadataClient() *ec2metadata.EC2Metadata {
+	config := aws.NewConfig()
+
+	if *imdsEndpoint != "" {
+		config = config.WithEndpoint(*imdsEndpoint)
+	}
+
+	return ec2metadata.New(session.New(), config)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sysfsSectorSize is the unit of the size files in sysfs, which doesn't
+	// depend on the logical sector size of the device.
+	sysfsSectorSize = 512
+	// GiB is the unit of EBS volume sizes.
+	GiB = 1073741824
+
+	blockDeviceSizePollInterval = 5 * time.Second
+)
+
+// readBlockDeviceSize returns the size of the block device in bytes. The
+// sysfsPath is the directory of the device in sysfs.
+func readBlockDeviceSize(sysfsPath string) (int64, error) {
+	size, err := ioutil.ReadFile(filepath.Join(sysfsPath, "size"))
+	if err != nil {
+		return 0, err
+	}
+
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(size)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong size of \"%s\": %s", sysfsPath, err)
+	}
+
+	return sectors * sysfsSectorSize, nil
+}
+
+// deviceMajor and deviceMinor decode the device number like the major()
+// and minor() macros of glibc do. The kernel keeps the lower 8 bits of the
+// minor number and 12 bits of the major number in the lower 20 bits of dev_t
+// for compatibility, the rest of them is stored in the upper bits. So minor
+// numbers above 255, e.g. of NVMe namespaces and partitions on hosts with
+// many volumes, can't be decoded as dev_t / 256 and dev_t % 256.
+func deviceMajor(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000)
+}
+
+func deviceMinor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) & 0xffffff00)
+}
+
+// rescanBlockDevice asks the kernel to check the size of the disk again.
+// SCSI disks have the rescan file, NVMe controllers have rescan_controller.
+func rescanBlockDevice(device string) {
+	deviceSysPath := filepath.Join(*hostSysPath, "class", "block", device, "device")
+
+	for _, rescanFile := range []string{"rescan", "rescan_controller"} {
+		rescanPath := filepath.Join(deviceSysPath, rescanFile)
+		if _, err := os.Stat(rescanPath); err != nil {
+			continue
+		}
+
+		log.Debugf("Writing to \"%s\"...", rescanPath)
+		if err := ioutil.WriteFile(rescanPath, []byte("1"), 0200); err != nil {
+			log.Debugf("Couldn't rescan \"%s\": %s", device, err)
+		}
+		return
+	}
+}
+
+// DeviceSizeTimeoutError is returned when the kernel doesn't report the
+// expected size of the disk in time.
+type DeviceSizeTimeoutError struct {
+	Device       string
+	ExpectedSize int64
+	CurrentSize  int64
+	Timeout      time.Duration
+}
+
+func (err *DeviceSizeTimeoutError) Error() string {
+	return fmt.Sprintf("The kernel still reports %d bytes instead of %d bytes for \"%s\" after %s. The EBS volume modification may be still in progress, or the device doesn't support rescanning", err.CurrentSize, err.ExpectedSize, err.Device, err.Timeout)
+}
+
+// WaitForBlockDeviceSize triggers a rescan of the disk and waits until the
+// kernel reports the expected size of it in bytes. If the size isn't reached
+// within the timeout, *DeviceSizeTimeoutError is returned.
+func WaitForBlockDeviceSize(device string, expectedSize int64, timeout time.Duration) error {
+	deviceSysPath := filepath.Join(*hostSysPath, "class", "block", device)
+	deadline := time.Now().Add(timeout)
+
+	for {
+		rescanBlockDevice(device)
+
+		size, err := readBlockDeviceSize(deviceSysPath)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Current size of \"%s\": %d bytes, expected: %d bytes", device, size, expectedSize)
+
+		if size >= expectedSize {
+			log.Infof("The kernel reports the new size of \"%s\": %d bytes.", device, size)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &DeviceSizeTimeoutError{
+				Device:       device,
+				ExpectedSize: expectedSize,
+				CurrentSize:  size,
+				Timeout:      timeout,
+			}
+		}
+
+		time.Sleep(blockDeviceSizePollInterval)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testSysPath is a fake sysfs tree with an LVM logical volume of a large dm
+// minor number on a partition of an NVMe namespace of a large minor number:
+// dm-70000 (253:70000) -> nvme7n1p1 (259:301) -> nvme7n1 (259:300).
+const testSysPath = "testdata/sysfs"
+
+// useSysPath points sys-path to the sysfs tree for the duration of the test.
+func useSysPath(t *testing.T, sysPath string) {
+	originalSysPath := *hostSysPath
+	*hostSysPath = sysPath
+	t.Cleanup(func() { *hostSysPath = originalSysPath })
+}
+
+// makeDev encodes the device number like the makedev() macro of glibc does.
+func makeDev(major, minor uint64) uint64 {
+	return (minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32)
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	tests := []struct {
+		dev   uint64
+		major uint64
+		minor uint64
+	}{
+		{0x10301, 259, 1},
+		{0x11032c, 259, 300},
+		{0x11032d, 259, 301},
+		{0x1110fd70, 253, 70000},
+		{0xfd00, 253, 0},
+		{0x800, 8, 0},
+		{0x1000000fff00, 0x1fff, 0},
+	}
+
+	for _, test := range tests {
+		if dev := makeDev(test.major, test.minor); dev != test.dev {
+			t.Errorf("%d:%d: expected %#x, got %#x", test.major, test.minor, test.dev, dev)
+		}
+		if major, minor := deviceMajor(test.dev), deviceMinor(test.dev); major != test.major || minor != test.minor {
+			t.Errorf("%#x: expected %d:%d, got %d:%d", test.dev, test.major, test.minor, major, minor)
+		}
+	}
+}
+
+func TestGetDeviceNumberSysPath(t *testing.T) {
+	useSysPath(t, testSysPath)
+
+	for _, majorMinor := range [][2]uint64{{259, 300}, {259, 301}, {253, 70000}} {
+		if _, err := getDeviceNumberSysPath(majorMinor[0], majorMinor[1]); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// 259:44 is the result of decoding 259:300 as dev_t / 256 and dev_t % 256.
+	dev := makeDev(259, 300)
+	if _, err := getDeviceNumberSysPath(dev>>8&0xff, dev&0xff); err == nil {
+		t.Errorf("Expected an error for the truncated device number %d:%d", dev>>8&0xff, dev&0xff)
+	}
+}
+
+func TestReadBlockDeviceLargeMinors(t *testing.T) {
+	useSysPath(t, testSysPath)
+	useCommandExecutor(t, map[string]string{"dmsetup table vg0-data": "0 209713152 linear 259:301 2048\n"})
+
+	deviceSysPath, err := getDeviceNumberSysPath(253, 70000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logicalVolume, err := ReadBlockDevice(deviceSysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logicalVolume.Kind != DeviceKindDMLinear || logicalVolume.MajorMinor() != "253:70000" || logicalVolume.MapperName != "vg0-data" {
+		t.Fatalf("Expected the LVM logical volume vg0-data (253:70000), got %s \"%s\" (%s)", logicalVolume.Kind, logicalVolume.MapperName, logicalVolume.MajorMinor())
+	}
+
+	partition := logicalVolume.findChild("259:301")
+	if partition == nil || partition.Kind != DeviceKindPartition || partition.Name != "nvme7n1p1" {
+		t.Fatalf("Expected the partition nvme7n1p1 (259:301) under the logical volume")
+	}
+
+	disks := logicalVolume.Disks()
+	if len(disks) != 1 || disks[0].Name != "nvme7n1" || disks[0].MajorMinor() != "259:300" || disks[0].Kind != DeviceKindNVMeDisk {
+		t.Fatalf("Expected the NVMe disk nvme7n1 (259:300), got %v", disks)
+	}
+}
+
+func TestGetDeviceFileSysPathLargeMinors(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating device files requires root")
+	}
+	useSysPath(t, testSysPath)
+
+	directory := t.TempDir()
+	for name, majorMinor := range map[string][2]uint64{"nvme7n1": {259, 300}, "dm-70000": {253, 70000}} {
+		devicePath := filepath.Join(directory, name)
+		if err := syscall.Mknod(devicePath, syscall.S_IFBLK|0600, int(makeDev(majorMinor[0], majorMinor[1]))); err != nil {
+			t.Skipf("Couldn't create the device file: %s", err)
+		}
+
+		deviceSysPath, err := getDeviceFileSysPath(devicePath)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := filepath.Join(testSysPath, "dev", "block", formatMajorMinor(majorMinor)); deviceSysPath != expected {
+			t.Errorf("%s: expected \"%s\", got \"%s\"", name, expected, deviceSysPath)
+		}
+	}
+}
+
+func formatMajorMinor(majorMinor [2]uint64) string {
+	return (&BlockDevice{Major: uint32(majorMinor[0]), Minor: uint32(majorMinor[1])}).MajorMinor()
+}
+
+// writeBlockDeviceSize writes the size file of the device to the sysfs tree.
+func writeBlockDeviceSize(t *testing.T, sysPath, device string, size int64) {
+	deviceSysPath := filepath.Join(sysPath, "class", "block", device)
+	if err := os.MkdirAll(deviceSysPath, 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(deviceSysPath, "size"), []byte(fmt.Sprintf("%d\n", size/sysfsSectorSize)), 0644); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWaitForBlockDeviceSize(t *testing.T) {
+	sysPath := t.TempDir()
+	useSysPath(t, sysPath)
+	writeBlockDeviceSize(t, sysPath, "nvme1n1", 100*GiB)
+
+	// The kernel reports the new size after the first poll.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writeBlockDeviceSize(t, sysPath, "nvme1n1", 120*GiB)
+	}()
+
+	if err := WaitForBlockDeviceSize("nvme1n1", 120*GiB, 2*blockDeviceSizePollInterval); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForBlockDeviceSizeTimeout(t *testing.T) {
+	sysPath := t.TempDir()
+	useSysPath(t, sysPath)
+	writeBlockDeviceSize(t, sysPath, "nvme1n1", 100*GiB)
+
+	err := WaitForBlockDeviceSize("nvme1n1", 120*GiB, 0)
+	var timeoutError *DeviceSizeTimeoutError
+	if !errors.As(err, &timeoutError) {
+		t.Fatalf("Expected *DeviceSizeTimeoutError, got %v", err)
+	}
+	if timeoutError.CurrentSize != 100*GiB || timeoutError.ExpectedSize != 120*GiB {
+		t.Errorf("Expected %d of %d bytes, got %d of %d", int64(100*GiB), int64(120*GiB), timeoutError.CurrentSize, timeoutError.ExpectedSize)
+	}
+	if expected := `The kernel still reports 107374182400 bytes instead of 128849018880 bytes for "nvme1n1" after 0s.`; !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("Expected the message starting with \"%s\", got \"%s\"", expected, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// btrfsIocResize is _IOW(0x94, 3, struct btrfs_ioctl_vol_args).
+	btrfsIocResize = 0x50009403
+	// btrfsIocDevInfo is _IOWR(0x94, 30, struct btrfs_ioctl_dev_info_args).
+	btrfsIocDevInfo = 0xD000941E
+	// btrfsIocFSInfo is _IOR(0x94, 31, struct btrfs_ioctl_fs_info_args).
+	btrfsIocFSInfo = 0x8400941F
+)
+
+// btrfsVolArgs is struct btrfs_ioctl_vol_args from linux/btrfs.h.
+type btrfsVolArgs struct {
+	FD   int64
+	Name [4088]byte
+}
+
+// btrfsDevInfoArgs is struct btrfs_ioctl_dev_info_args from linux/btrfs.h.
+type btrfsDevInfoArgs struct {
+	DevID      uint64
+	UUID       [16]byte
+	BytesUsed  uint64
+	TotalBytes uint64
+	Unused     [379]uint64
+	Path       [1024]byte
+}
+
+// btrfsFSInfoArgs is struct btrfs_ioctl_fs_info_args from linux/btrfs.h.
+type btrfsFSInfoArgs struct {
+	MaxID          uint64
+	NumDevices     uint64
+	FSID           [16]byte
+	NodeSize       uint32
+	SectorSize     uint32
+	CloneAlignment uint32
+	CsumType       uint16
+	CsumSize       uint16
+	Flags          uint64
+	Generation     uint64
+	MetadataUUID   [16]byte
+	Reserved       [944]byte
+}
+
+// readBtrfsFSInfo gets the information about the btrfs filesystem
+// containing the opened directory.
+func readBtrfsFSInfo(directory *os.File) (*btrfsFSInfoArgs, error) {
+	fsInfo := &btrfsFSInfoArgs{}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), btrfsIocFSInfo, uintptr(unsafe.Pointer(fsInfo)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return fsInfo, nil
+}
+
+// readBtrfsDevInfo gets the information about the device with the devid.
+// Missing devids of removed devices are reported as ENODEV.
+func readBtrfsDevInfo(directory *os.File, devID uint64) (*btrfsDevInfoArgs, error) {
+	devInfo := &btrfsDevInfoArgs{DevID: devID}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), btrfsIocDevInfo, uintptr(unsafe.Pointer(devInfo)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return devInfo, nil
+}
+
+// getBtrfsMemberDevices returns sysfs paths of all devices of the mounted
+// btrfs filesystem. They're listed in /sys/fs/btrfs/<fsid>/devices.
+func getBtrfsMemberDevices(mount *MountInfo) ([]string, error) {
+	directory, err := os.Open(namespacePath(mount.MountPoint))
+	if err != nil {
+		return nil, err
+	}
+	defer directory.Close()
+
+	fsInfo, err := readBtrfsFSInfo(directory)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get btrfs information of the filesystem mounted on \"%s\": %s", mount.MountPoint, err)
+	}
+
+	fsid := fsInfo.FSID
+	devicesPath := filepath.Join(*hostSysPath, "fs", "btrfs",
+		fmt.Sprintf("%x-%x-%x-%x-%x", fsid[0:4], fsid[4:6], fsid[6:8], fsid[8:10], fsid[10:16]), "devices")
+	log.Debugf("Btrfs devices path in %s: %s", *hostSysPath, devicesPath)
+
+	devices, err := ioutil.ReadDir(devicesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var devicesPathsList []string
+	for _, device := range devices {
+		devicesPathsList = append(devicesPathsList, filepath.Join(devicesPath, device.Name()))
+	}
+
+	if len(devicesPathsList) == 0 {
+		return nil, fmt.Errorf("No devices found in \"%s\"", devicesPath)
+	}
+
+	return devicesPathsList, nil
+}
+
+// resizeBtrfs grows every device of the mounted btrfs filesystem to its
+// maximum size with the BTRFS_IOC_RESIZE ioctl, like
+// "btrfs filesystem resize <devid>:max" does.
+func resizeBtrfs(mount *MountInfo) error {
+	directory, err := os.Open(namespacePath(mount.MountPoint))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+
+	fsInfo, err := readBtrfsFSInfo(directory)
+	if err != nil {
+		return fmt.Errorf("Couldn't get btrfs information of the filesystem mounted on \"%s\": %s", mount.MountPoint, err)
+	}
+
+	for devID := uint64(1); devID <= fsInfo.MaxID; devID++ {
+		devInfo, err := readBtrfsDevInfo(directory, devID)
+		if err == syscall.ENODEV {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Couldn't get information of btrfs device %d: %s", devID, err)
+		}
+		devicePath := string(devInfo.Path[:clen(devInfo.Path[:])])
+
+		volArgs := &btrfsVolArgs{}
+		copy(volArgs.Name[:], strconv.FormatUint(devID, 10)+":max")
+
+		log.Infof("Resizing btrfs device %d \"%s\" to the maximum size...", devID, devicePath)
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), btrfsIocResize, uintptr(unsafe.Pointer(volArgs)))
+		if errno != 0 {
+			return fmt.Errorf("Couldn't resize btrfs device %d \"%s\": %s", devID, devicePath, errno)
+		}
+
+		resizedDevInfo, err := readBtrfsDevInfo(directory, devID)
+		if err != nil {
+			return fmt.Errorf("Couldn't get information of btrfs device %d: %s", devID, err)
+		}
+		log.Infof("Btrfs device %d \"%s\" was resized from %d to %d bytes.", devID, devicePath, devInfo.TotalBytes, resizedDevInfo.TotalBytes)
+	}
+
+	return nil
+}
+
+// clen returns the length of a NUL-terminated string in the buffer.
+func clen(buffer []byte) int {
+	for index, character := range buffer {
+		if character == 0 {
+			return index
+		}
+	}
+	return len(buffer)
+}
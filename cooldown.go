@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ebsModificationCooldown is the time EBS requires between modifications
+// of a volume.
+const ebsModificationCooldown = 6 * time.Hour
+
+// modificationPollInterval is how often in-progress modifications are
+// checked, because their end can't be predicted.
+const modificationPollInterval = time.Minute
+
+// CooldownError is returned when a volume can't be modified yet, because it
+// was modified less than six hours ago or its modification is in progress.
+type CooldownError struct {
+	VolumeID         string
+	LastModification time.Time
+	// ModificationState is the state of the last modification, e.g.
+	// optimizing.
+	ModificationState string
+	// RetryAfter is the earliest time the volume can be modified again. For
+	// in-progress modifications it's the time of the next check.
+	RetryAfter time.Time
+}
+
+func (err *CooldownError) Error() string {
+	if err.InProgress() {
+		return fmt.Sprintf("The modification of EBS volume %s started at %s is still %s, so the volume can't be modified again until it completes, not before %s",
+			err.VolumeID, err.LastModification.Format(time.RFC3339), err.ModificationState, err.RetryAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("EBS volume %s was modified at %s, so it can't be modified again until %s",
+		err.VolumeID, err.LastModification.Format(time.RFC3339), err.RetryAfter.Format(time.RFC3339))
+}
+
+// InProgress reports whether the last modification isn't completed yet.
+// EBS refuses new modifications until it completes, even after six hours.
+func (err *CooldownError) InProgress() bool {
+	return isModificationInProgress(err.ModificationState)
+}
+
+func isModificationInProgress(state string) bool {
+	return state == ec2.VolumeModificationStateModifying || state == ec2.VolumeModificationStateOptimizing
+}
+
+// GetModificationCooldowns returns the cooldowns of the volumes, which were
+// modified less than six hours ago or whose modification is in progress.
+// Failed modifications don't count.
+func GetModificationCooldowns(volumeIDsList []string) ([]*CooldownError, error) {
+	svc, err := newEC2Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var modifications []*ec2.VolumeModification
+	err = describeVolumesModifications(svc, volumeIDsList, func(modification *ec2.VolumeModification) {
+		modifications = append(modifications, modification)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return modificationCooldowns(volumeIDsList, modifications, time.Now()), nil
+}
+
+// modificationCooldowns finds the last modification of every volume and
+// returns the cooldowns, which last at the time now.
+func modificationCooldowns(volumeIDsList []string, modifications []*ec2.VolumeModification, now time.Time) []*CooldownError {
+	lastModifications := make(map[string]*ec2.VolumeModification)
+	for _, modification := range modifications {
+		if aws.StringValue(modification.ModificationState) == ec2.VolumeModificationStateFailed {
+			continue
+		}
+		volumeID := aws.StringValue(modification.VolumeId)
+		last, ok := lastModifications[volumeID]
+		if !ok || aws.TimeValue(modification.StartTime).After(aws.TimeValue(last.StartTime)) {
+			lastModifications[volumeID] = modification
+		}
+	}
+
+	var cooldowns []*CooldownError
+	for _, volumeID := range volumeIDsList {
+		modification, ok := lastModifications[volumeID]
+		if !ok {
+			continue
+		}
+		lastModification := aws.TimeValue(modification.StartTime)
+		state := aws.StringValue(modification.ModificationState)
+		log.Debugf("EBS volume %s was last modified at %s, the modification is %s.", volumeID, lastModification.Format(time.RFC3339), state)
+
+		retryAfter := lastModification.Add(ebsModificationCooldown)
+		if isModificationInProgress(state) && !now.Before(retryAfter) {
+			retryAfter = now.Add(modificationPollInterval)
+		}
+		if now.Before(retryAfter) {
+			cooldowns = append(cooldowns, &CooldownError{
+				VolumeID:          volumeID,
+				LastModification:  lastModification,
+				ModificationState: state,
+				RetryAfter:        retryAfter,
+			})
+		}
+	}
+
+	return cooldowns
+}
+
+// WaitForModificationCooldown returns when all volumes can be modified. If
+// the cooldown of any volume lasts longer than maxWait, the CooldownError
+// of the volume with the latest retry time is returned without waiting.
+// In-progress modifications are checked again until they complete.
+func WaitForModificationCooldown(volumeIDsList []string, maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+
+	for {
+		cooldowns, err := GetModificationCooldowns(volumeIDsList)
+		if err != nil {
+			return err
+		}
+		if len(cooldowns) == 0 {
+			return nil
+		}
+
+		latest := cooldowns[0]
+		for _, cooldown := range cooldowns[1:] {
+			if cooldown.RetryAfter.After(latest.RetryAfter) {
+				latest = cooldown
+			}
+		}
+
+		if latest.RetryAfter.After(deadline) {
+			return latest
+		}
+
+		wait := time.Until(latest.RetryAfter)
+		log.Infof("%s. Waiting for %s...", latest, wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
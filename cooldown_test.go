@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestModificationCooldowns(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	modification := func(volumeID, state string, age time.Duration) *ec2.VolumeModification {
+		return &ec2.VolumeModification{
+			VolumeId:          aws.String(volumeID),
+			ModificationState: aws.String(state),
+			StartTime:         aws.Time(now.Add(-age)),
+		}
+	}
+	modifications := []*ec2.VolumeModification{
+		modification("vol-recent", ec2.VolumeModificationStateCompleted, 2*time.Hour),
+		modification("vol-old", ec2.VolumeModificationStateCompleted, 7*time.Hour),
+		modification("vol-failed", ec2.VolumeModificationStateFailed, time.Hour),
+		modification("vol-failed", ec2.VolumeModificationStateCompleted, 8*time.Hour),
+		modification("vol-optimizing", ec2.VolumeModificationStateOptimizing, 7*time.Hour),
+		modification("vol-modifying", ec2.VolumeModificationStateModifying, 30*time.Minute),
+		modification("vol-latest", ec2.VolumeModificationStateCompleted, 10*time.Hour),
+		modification("vol-latest", ec2.VolumeModificationStateCompleted, 5*time.Hour),
+	}
+	volumeIDsList := []string{"vol-recent", "vol-old", "vol-failed", "vol-optimizing", "vol-modifying", "vol-latest", "vol-unmodified"}
+
+	expected := map[string]time.Time{
+		"vol-recent": now.Add(4 * time.Hour),
+		// The modification is still in progress after six hours.
+		"vol-optimizing": now.Add(modificationPollInterval),
+		"vol-modifying":  now.Add(5*time.Hour + 30*time.Minute),
+		"vol-latest":     now.Add(time.Hour),
+	}
+
+	cooldowns := modificationCooldowns(volumeIDsList, modifications, now)
+	if len(cooldowns) != len(expected) {
+		t.Errorf("Expected %d cooldowns, got %d", len(expected), len(cooldowns))
+	}
+	for _, cooldown := range cooldowns {
+		retryAfter, ok := expected[cooldown.VolumeID]
+		if !ok {
+			t.Errorf("Unexpected cooldown: %s", cooldown)
+			continue
+		}
+		if !cooldown.RetryAfter.Equal(retryAfter) {
+			t.Errorf("%s: expected the retry time %s, got %s", cooldown.VolumeID, retryAfter, cooldown.RetryAfter)
+		}
+		if inProgress := cooldown.VolumeID == "vol-optimizing" || cooldown.VolumeID == "vol-modifying"; cooldown.InProgress() != inProgress {
+			t.Errorf("%s: expected in progress %t", cooldown.VolumeID, inProgress)
+		}
+	}
+}
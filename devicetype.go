@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiskStorage is the storage a disk is provided by.
+type DiskStorage string
+
+const (
+	DiskStorageEBS           DiskStorage = "ebs"
+	DiskStorageInstanceStore DiskStorage = "instance-store"
+	DiskStorageVirtio        DiskStorage = "virtio"
+	DiskStorageLoop          DiskStorage = "loop"
+	DiskStorageUnknown       DiskStorage = "unknown"
+)
+
+// NonEBSDeviceError is returned for a leaf of the storage stack which isn't
+// an EBS volume, so it can't be enlarged.
+type NonEBSDeviceError struct {
+	Device  string
+	Storage DiskStorage
+	Reason  string
+}
+
+func (err *NonEBSDeviceError) Error() string {
+	return fmt.Sprintf("Device \"%s\" is not an EBS volume: %s", err.Device, err.Reason)
+}
+
+// NonEBSDevicesError is returned when some leaves of the storage stack aren't
+// EBS volumes. Stacks mixing EBS volumes with other storage can't be grown
+// consistently, so they're refused as a whole.
+type NonEBSDevicesError struct {
+	Devices []*NonEBSDeviceError
+}
+
+func (err *NonEBSDevicesError) Error() string {
+	var reasons []string
+	for _, device := range err.Devices {
+		reasons = append(reasons, device.Error())
+	}
+	return "The storage stack can't be grown. " + strings.Join(reasons, ". ")
+}
+
+// classifyDevice returns the storage of the leaf device of a storage stack
+// by its kind and NVMe model. The error explains why the device can't be
+// grown if it's known not to be an EBS volume. NVMe disks without a model
+// in sysfs and Xen disks are classified as EBS volumes, they're checked by
+// their serials or attachments later.
+func classifyDevice(device *BlockDevice) (DiskStorage, error) {
+	switch device.Kind {
+	case DeviceKindLoop:
+		reason := "it's a loop device without a backing file"
+		if device.BackingFile != "" {
+			reason = fmt.Sprintf("it's a loop device backed by the file \"%s\", which has to be grown instead", device.BackingFile)
+		}
+		return DiskStorageLoop, &NonEBSDeviceError{Device: device.Name, Storage: DiskStorageLoop, Reason: reason}
+	case DeviceKindVirtioDisk:
+		return DiskStorageVirtio, &NonEBSDeviceError{
+			Device:  device.Name,
+			Storage: DiskStorageVirtio,
+			Reason:  "it's a virtio disk, which isn't provided by EBS",
+		}
+	case DeviceKindNVMeDisk:
+		return classifyNVMeModel(device.Name, device.Model)
+	case DeviceKindXVDDisk:
+		return DiskStorageEBS, nil
+	case DeviceKindDisk:
+		return DiskStorageUnknown, nil
+	}
+
+	return DiskStorageUnknown, &NonEBSDeviceError{
+		Device:  device.Name,
+		Storage: DiskStorageUnknown,
+		Reason:  fmt.Sprintf("it's a %s device without underlying disks", device.Kind),
+	}
+}
+
+// classifyNVMeModel returns the storage of the NVMe disk by its model.
+func classifyNVMeModel(device, model string) (DiskStorage, error) {
+	switch model {
+	case ebsNVMeModel, "":
+		return DiskStorageEBS, nil
+	case instanceStoreNVMeModel:
+		return DiskStorageInstanceStore, &NonEBSDeviceError{
+			Device:  device,
+			Storage: DiskStorageInstanceStore,
+			Reason:  "it's an NVMe instance store volume, whose size is fixed by the instance type",
+		}
+	}
+
+	return DiskStorageUnknown, &NonEBSDeviceError{
+		Device:  device,
+		Storage: DiskStorageUnknown,
+		Reason:  fmt.Sprintf("its NVMe model is \"%s\"", model),
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassifyDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		device  *BlockDevice
+		storage DiskStorage
+		reason  string
+	}{
+		{"NVMe EBS volume", &BlockDevice{Name: "nvme1n1", Kind: DeviceKindNVMeDisk, Model: ebsNVMeModel}, DiskStorageEBS, ""},
+		{"NVMe disk without a model", &BlockDevice{Name: "nvme1n1", Kind: DeviceKindNVMeDisk}, DiskStorageEBS, ""},
+		{"NVMe instance store", &BlockDevice{Name: "nvme2n1", Kind: DeviceKindNVMeDisk, Model: instanceStoreNVMeModel}, DiskStorageInstanceStore, "instance store"},
+		{"other NVMe disk", &BlockDevice{Name: "nvme3n1", Kind: DeviceKindNVMeDisk, Model: "Samsung SSD 970"}, DiskStorageUnknown, "Samsung SSD 970"},
+		{"Xen disk", &BlockDevice{Name: "xvdf", Kind: DeviceKindXVDDisk}, DiskStorageEBS, ""},
+		{"virtio disk", &BlockDevice{Name: "vda", Kind: DeviceKindVirtioDisk}, DiskStorageVirtio, "virtio"},
+		{"attached loop device", &BlockDevice{Name: "loop0", Kind: DeviceKindLoop, BackingFile: "/var/lib/images/disk.img"}, DiskStorageLoop, "/var/lib/images/disk.img"},
+		{"detached loop device", &BlockDevice{Name: "loop1", Kind: DeviceKindLoop}, DiskStorageLoop, "without a backing file"},
+		{"SCSI disk", &BlockDevice{Name: "sda", Kind: DeviceKindDisk}, DiskStorageUnknown, ""},
+		{"device mapper device without disks", &BlockDevice{Name: "dm-0", Kind: DeviceKindDM}, DiskStorageUnknown, "dm device"},
+	}
+
+	for _, test := range tests {
+		storage, err := classifyDevice(test.device)
+		if storage != test.storage {
+			t.Errorf("%s: expected the %s storage, got %s", test.name, test.storage, storage)
+		}
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+			continue
+		}
+
+		var nonEBSDevice *NonEBSDeviceError
+		if !errors.As(err, &nonEBSDevice) {
+			t.Errorf("%s: expected *NonEBSDeviceError, got %v", test.name, err)
+			continue
+		}
+		if nonEBSDevice.Device != test.device.Name || nonEBSDevice.Storage != test.storage || !strings.Contains(nonEBSDevice.Reason, test.reason) {
+			t.Errorf("%s: unexpected error %+v", test.name, *nonEBSDevice)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+// cryptUUIDPrefix is the prefix of device mapper UUIDs of dm-crypt mappings
+// created by cryptsetup.
+const cryptUUIDPrefix = "CRYPT-"
+
+// ResizeCryptLayers resizes the dm-crypt mappings to the size of their
+// underlying devices with "cryptsetup resize". The deepest mappings have to
+// come first, as StorageStack.DevicesOfKind returns them.
+func ResizeCryptLayers(layers []*BlockDevice) error {
+	for _, layer := range layers {
+		log.Infof("Resizing dm-crypt mapping \"%s\"...", layer.MapperName)
+		if _, err := commandExecutor.Execute("cryptsetup", "resize", layer.MapperName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
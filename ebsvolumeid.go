@@ -1,175 +1,312 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
-func getListOfSecondaryDevices(secondariesList []string, devicesPathsList []string) ([]string, []string) {
-	if len(secondariesList) == 0 {
-		return nil, devicesPathsList
+// ebsSerialRegexp matches complete EBS volume IDs without the dash, as EBS
+// reports them in NVMe serial numbers.
+var ebsSerialRegexp = regexp.MustCompile(`^vol([0-9a-f]{8}|[0-9a-f]{17})$`)
+
+// mountInfoPath returns the path to the mountinfo file of the process whose
+// mount namespace is used to resolve mount points.
+func mountInfoPath() string {
+	if *targetPID > 0 {
+		return filepath.Join(*hostProcPath, strconv.Itoa(*targetPID), "mountinfo")
 	}
+	return filepath.Join(*hostProcPath, "self", "mountinfo")
+}
 
-	// Use the first item from the secondariesList as the current devicePath.
-	devicePath := secondariesList[0]
-	log.Debugln("Path in the current secondaries search iteration:", devicePath)
+// namespacePath translates a path as seen by the target process to the path
+// reachable from the current mount namespace.
+func namespacePath(path string) string {
+	if *targetPID > 0 {
+		return filepath.Join(*hostProcPath, strconv.Itoa(*targetPID), "root", path)
+	}
+	return path
+}
 
-	pathForSearchSecondaries := filepath.Join(devicePath, "slaves")
+// findMountOfPath returns the mount containing the path in the mount
+// namespace used to resolve mount points. The path has to be absolute and
+// exist, and the device of the mount has to be the device of the path, so
+// a typo doesn't resolve to the root filesystem.
+func findMountOfPath(mountPoint string) (*MountInfo, error) {
+	if !filepath.IsAbs(mountPoint) {
+		return nil, fmt.Errorf("Path \"%s\" isn't absolute", mountPoint)
+	}
 
-	// Delete the current being checked item from the secondariesList.
-	previous := len(secondariesList) - 1
-	secondariesList[0] = secondariesList[previous]
-	secondariesList = secondariesList[:previous]
+	pathInfo, err := os.Stat(namespacePath(mountPoint))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't find the path \"%s\": %w", mountPoint, err)
+	}
+	dev := uint64(pathInfo.Sys().(*syscall.Stat_t).Dev)
 
-	// Check if the current device path has slaves directory.
-	openPathForSearchSecondaries, err := os.Open(pathForSearchSecondaries)
+	// Get mount points.
+	mounts, err := ReadMountInfo(mountInfoPath())
 	if err != nil {
-		if osError, ok := err.(*os.PathError); ok {
-			switch osError.Err.(syscall.Errno) {
-			case syscall.ENOENT:
-				// If not, append it to the devicesPathsList.
-				devicesPathsList = append(devicesPathsList, devicePath)
-				return getListOfSecondaryDevices(secondariesList, devicesPathsList)
-			default:
-				log.Fatalln(osError.Error())
-			}
-		}
-		log.Fatalln(err.Error())
+		return nil, err
 	}
-	defer openPathForSearchSecondaries.Close()
 
-	// Also append the current device path in the devicesPathsList if it has empty slaves directory.
-	_, err = openPathForSearchSecondaries.Readdirnames(1)
-	if err == io.EOF {
-		devicesPathsList = append(devicesPathsList, devicePath)
-		return getListOfSecondaryDevices(secondariesList, devicesPathsList)
+	// Resolve symlinks, so the path can be compared with mount points.
+	// Symlinks can't be resolved reliably in a foreign mount namespace, because
+	// absolute links would point to the current one.
+	path := mountPoint
+	if *targetPID > 0 {
+		log.Debugf("Resolving \"%s\" in the mount namespace of the process %d.", path, *targetPID)
+	} else if resolvedPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolvedPath
+	} else {
+		log.Debugf("Couldn't resolve symlinks of \"%s\": %s", path, err)
 	}
 
-	// Write absolute paths of secondaries from the slaves directory to the secondariesList.
-	secondaries, err := ioutil.ReadDir(pathForSearchSecondaries)
+	// Search the mount containing the path in the mountinfo file.
+	mount, err := FindMountByPath(mounts, path)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	for _, secondary := range secondaries {
-		secondariesList = append(secondariesList, filepath.Join(pathForSearchSecondaries, secondary.Name()))
+
+	// Nested btrfs subvolumes have their own anonymous device numbers, which
+	// don't appear in mountinfo.
+	if uint64(mount.Major) != deviceMajor(dev) || uint64(mount.Minor) != deviceMinor(dev) {
+		if mount.FSType != "btrfs" {
+			return nil, fmt.Errorf("Path \"%s\" is located on the device %d:%d, but the mount \"%s\" found for it is %d:%d",
+				mountPoint, deviceMajor(dev), deviceMinor(dev), mount.MountPoint, mount.Major, mount.Minor)
+		}
+		log.Debugf("Path \"%s\" is located on the btrfs subvolume %d:%d of the mount \"%s\".", mountPoint, deviceMajor(dev), deviceMinor(dev), mount.MountPoint)
 	}
-	log.Debugf("Secondaries list in the current secondaries search iteration: %v", secondariesList)
 
-	return getListOfSecondaryDevices(secondariesList, devicesPathsList)
+	return mount, nil
+}
+
+// EBSVolume is an EBS volume backing a mount point.
+type EBSVolume struct {
+	VolumeID string
+	// Device is the name of the disk, e.g. nvme1n1.
+	Device string
+	// Partitions lists the partitions of the disk used by the mount point.
+	Partitions []Partition
+}
+
+// Partition is a partition of a disk.
+type Partition struct {
+	Name   string
+	Number int
 }
 
-func appendDeviceIfUnique(parentDevicesList []string, parentDevice string) []string {
-	for _, item := range parentDevicesList {
-		if item == parentDevice {
-			return parentDevicesList
+// GetEBSVolumes returns the EBS volumes of the disks of the storage stack
+// along with the partitions of the disks used by the stack. If the program
+// is running in AWS, then the serial number of a disk is the EBS VolumeID.
+func GetEBSVolumes(stack *StorageStack) ([]EBSVolume, error) {
+	var volumesList []EBSVolume
+	var nonEBSDevices []*NonEBSDeviceError
+	for _, disk := range stack.Leaves() {
+		log.Debugln("Parental device:", disk.Name)
+
+		// Devices are classified from sysfs first, so the ones, which can't
+		// be EBS volumes, are reported even without device files.
+		_, err := classifyDevice(disk)
+		if err == nil {
+			err = checkDiskDeviceFile(disk)
 		}
+		var volumeID string
+		if err == nil {
+			volumeID, err = getEBSVolumeID(disk)
+		}
+		var nonEBSDevice *NonEBSDeviceError
+		if errors.As(err, &nonEBSDevice) {
+			log.Warnln(err)
+			nonEBSDevices = append(nonEBSDevices, nonEBSDevice)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		volume := EBSVolume{VolumeID: volumeID, Device: disk.Name}
+		for _, partition := range stack.DevicesOfKind(DeviceKindPartition) {
+			if partition.Children[0] == disk {
+				volume.Partitions = append(volume.Partitions, Partition{Name: partition.Name, Number: partition.PartitionNumber})
+			}
+		}
+		volumesList = append(volumesList, volume)
 	}
-	return append(parentDevicesList, parentDevice)
+
+	if len(nonEBSDevices) > 0 {
+		return nil, &NonEBSDevicesError{Devices: nonEBSDevices}
+	}
+
+	if len(volumesList) == 0 {
+		return nil, fmt.Errorf("No parental devices found in the storage stack. Try to run the program with -log-level=debug flag")
+	}
+
+	return volumesList, nil
 }
 
-// GetEBSVolumeIDsByMountPoint finds the device by its mount point, then
-// finds the serial number of that device. If the program is running in AWS,
-// then the serial number is the EBS VolumeID.
-func GetEBSVolumeIDsByMountPoint(mountPoint string) []string {
-	// Get mount points.
-	mountPoints, err := ioutil.ReadFile(*hostProcPath + "/self/mounts")
+// checkDiskDeviceFile returns an error if the disk has no block device file
+// in dev-path. The device file is needed to identify NVMe disks and to grow
+// partitions.
+func checkDiskDeviceFile(disk *BlockDevice) error {
+	fileInfo, err := os.Stat(disk.Path())
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Device file \"%s\" of the disk %s doesn't exist. Check the dev-path flag", disk.Path(), disk.MajorMinor())
+	}
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
+	if mode := fileInfo.Mode(); mode&os.ModeDevice != os.ModeDevice || mode&os.ModeCharDevice == os.ModeCharDevice {
+		return fmt.Errorf("\"%s\" is not a block device file", disk.Path())
+	}
+	return nil
+}
 
-	// Search mountPoint in the mount points file.
-	mountPointsFile := string(mountPoints)
-	mountPointRegexp := regexp.MustCompile(`\S+\s` + mountPoint + `\s.*`)
-	matches := mountPointRegexp.FindStringSubmatch(mountPointsFile)
-	mountPointString := strings.Split(matches[0], " ")
+// getMountDevicesSysPaths returns the paths of the mounted devices in sysfs.
+func getMountDevicesSysPaths(mount *MountInfo) ([]string, error) {
+	if mount.FSType == "btrfs" {
+		// A btrfs filesystem may span several devices, but mountinfo shows only one of them.
+		devicesPathsList, err := getBtrfsMemberDevices(mount)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Btrfs filesystem mounted on \"%s\" consists of %d devices.", mount.MountPoint, len(devicesPathsList))
+		return devicesPathsList, nil
+	}
 
-	deviceFileSystem := mountPointString[2]
-	device := mountPointString[0]
-	deviceInfo, err := os.Stat(device)
+	deviceSysPath, err := getMountDeviceSysPath(mount)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	return []string{deviceSysPath}, nil
+}
+
+// getMountDeviceSysPath returns the path of the mounted device in sysfs.
+func getMountDeviceSysPath(mount *MountInfo) (string, error) {
+	// Some filesystems report anonymous device numbers with the major
+	// number 0 in mountinfo, so the device file is used instead.
+	if mount.Major == 0 {
+		return getDeviceFileSysPath(namespacePath(mount.Source))
 	}
 
-	if !strings.HasPrefix(device, "/dev/") {
-		log.Fatalf("\"%s\" is not a device file.", device)
+	// The device numbers from mountinfo don't depend on the mount namespace.
+	return getDeviceNumberSysPath(uint64(mount.Major), uint64(mount.Minor))
+}
+
+// getDeviceFileSysPath returns the path in sysfs of the device the device
+// file refers to.
+func getDeviceFileSysPath(devicePath string) (string, error) {
+	deviceInfo, err := os.Stat(devicePath)
+	if err != nil {
+		return "", err
 	}
-	log.Infof("Found the device \"%s\" with %s filesystem matching the mount point \"%s\".", device, deviceFileSystem, mountPoint)
 
 	mode := deviceInfo.Mode()
 
-	if mode&os.ModeDevice != os.ModeDevice {
-		log.Fatalln("Wrong file mode of the device file.")
+	if mode&os.ModeDevice != os.ModeDevice || mode&os.ModeCharDevice == os.ModeCharDevice {
+		return "", fmt.Errorf("\"%s\" is not a block device file", devicePath)
 	}
 
-	// Search device path in sys.
-	deviceMajorNumber := deviceInfo.Sys().(*syscall.Stat_t).Rdev / 256
-	deviceMinorNumber := deviceInfo.Sys().(*syscall.Stat_t).Rdev % 256
+	rdev := uint64(deviceInfo.Sys().(*syscall.Stat_t).Rdev)
+
+	return getDeviceNumberSysPath(deviceMajor(rdev), deviceMinor(rdev))
+}
 
+// getDeviceNumberSysPath returns the path in sysfs of the block device with
+// the major and minor numbers.
+func getDeviceNumberSysPath(deviceMajorNumber, deviceMinorNumber uint64) (string, error) {
 	log.Debugf("Device major ID number and minor ID number: %d:%d", deviceMajorNumber, deviceMinorNumber)
 
 	deviceSysPath := *hostSysPath + "/dev/block/" + fmt.Sprintf("%d:%d", deviceMajorNumber, deviceMinorNumber)
 
 	if fileInfo, err := os.Stat(deviceSysPath); os.IsNotExist(err) || !fileInfo.IsDir() {
-		log.Fatalf("%s is not a folder or does not exist.", deviceSysPath)
+		return "", fmt.Errorf("%s is not a folder or does not exist", deviceSysPath)
 	}
 
 	log.Debugf("Device path in %s: %s", *hostSysPath, deviceSysPath)
 
-	// Get paths to physical devices of mount point.
-	secondariesList := []string{deviceSysPath}
-	secondariesList, devicesPathsList := getListOfSecondaryDevices(secondariesList, nil)
-
-	log.Debugf("Secondary devices of the device \"%s\": %v", device, devicesPathsList)
+	return deviceSysPath, nil
+}
 
-	var parentDevicesList []string
-	for _, path := range devicesPathsList {
-		deviceLink, err := filepath.EvalSymlinks(path)
-		if err != nil {
-			log.Debugln(err)
-		}
-		log.Debugln("Device link: " + deviceLink)
+// readPartition returns the partition located at the sysfs path or nil if
+// the device isn't a partition.
+func readPartition(deviceSysPath string) (*Partition, error) {
+	number, err := ioutil.ReadFile(filepath.Join(deviceSysPath, "partition"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		parentDevicePattern := regexp.MustCompile(`^.*\/(.*)\/.*$`)
-		parentDevice := parentDevicePattern.ReplaceAllString(deviceLink, `$1`)
-		log.Debugln("Parental device:", parentDevice)
+	partitionNumber, err := strconv.Atoi(strings.TrimSpace(string(number)))
+	if err != nil {
+		return nil, fmt.Errorf("Wrong partition number of \"%s\": %s", deviceSysPath, err)
+	}
 
-		if fileInfo, err := os.Stat(filepath.Join("/dev", parentDevice)); os.IsNotExist(err) || fileInfo.Mode()&os.ModeDevice != os.ModeDevice {
-			continue
-		}
+	return &Partition{Name: filepath.Base(deviceSysPath), Number: partitionNumber}, nil
+}
 
-		parentDevicesList = appendDeviceIfUnique(parentDevicesList, parentDevice)
+// getEBSVolumeID returns the EBS volume ID of the leaf device of a storage
+// stack. It's read from the serial in sysfs. If NVMe devices have no serial
+// there or it's truncated, the serial is read with the NVMe identify
+// controller command. Devices without serials, such as Xen ones, are
+// searched by their attachments. Devices which aren't EBS volumes are
+// reported with NonEBSDeviceError.
+func getEBSVolumeID(disk *BlockDevice) (string, error) {
+	if _, err := classifyDevice(disk); err != nil {
+		return "", err
 	}
+	device := disk.Name
 
-	log.Debugf("Parental devices list: %v", parentDevicesList)
-	if len(parentDevicesList) == 0 {
-		log.Fatalf("No parental devices of \"%s\" found. Try to run the program with -log-level=debug flag.", device)
+	serial, err := ioutil.ReadFile(*hostSysPath + "/class/block/" + device + "/device/serial")
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("Couldn't get serial of device \"%s\": %s", device, err)
 	}
+	volumeID := strings.TrimSpace(string(serial))
 
-	// Get list of parent devices volume ids.
-	var volumeIDsList []string
-	for _, parentDevice := range parentDevicesList {
-		serial, err := ioutil.ReadFile(*hostSysPath + "/class/block/" + parentDevice + "/device/serial")
+	if strings.HasPrefix(device, "nvme") && !ebsSerialRegexp.MatchString(volumeID) {
+		log.Infof("Device \"%s\" has no complete serial in sysfs. Reading NVMe identify controller data...", device)
+		identify, err := ReadNVMeIdentifyController(filepath.Join(*hostDevPath, device))
 		if err != nil {
-			log.Fatalf("Couldn't get serial of device \"%s\": %s", parentDevice, err)
+			log.Warnln(err)
+		} else {
+			log.Debugf("NVMe identify controller data of \"%s\": %+v", device, *identify)
+			if _, err := classifyNVMeModel(device, identify.ModelNumber); err != nil {
+				return "", err
+			}
+			if identify.BlockDeviceName != "" {
+				log.Infof("Device \"%s\" is mapped as \"%s\".", device, identify.BlockDeviceName)
+			}
+			volumeID = identify.SerialNumber
 		}
-		volumeID := strings.TrimSpace(string(serial))
-		log.Infof("Device \"%s\" serial is %s.", parentDevice, volumeID)
+	}
 
-		serialRegexp := regexp.MustCompile(`vol(.*)`)
-		hasVolumeIDEBSPrefix := serialRegexp.FindString(volumeID)
-		if hasVolumeIDEBSPrefix == "" {
-			log.Fatalf("Device \"%s\" is not an EBS volume.", parentDevice)
+	// Xen block devices have no serial, so the volume is searched among
+	// the volumes attached to the instance.
+	if volumeID == "" {
+		log.Infof("Device \"%s\" has no serial. Searching for the volume by its attachment...", device)
+		volumeID, err = GetEBSVolumeIDByAttachment(device)
+		if err != nil {
+			return "", err
 		}
+		log.Infof("Device \"%s\" is attached as the EBS volume %s.", device, volumeID)
+		return volumeID, nil
+	}
+	log.Infof("Device \"%s\" serial is %s.", device, volumeID)
 
-		volumeID = serialRegexp.ReplaceAllString(volumeID, `vol-$1`)
-		volumeIDsList = append(volumeIDsList, volumeID)
+	if !ebsSerialRegexp.MatchString(volumeID) {
+		return "", &NonEBSDeviceError{
+			Device:  device,
+			Storage: DiskStorageUnknown,
+			Reason:  fmt.Sprintf("its serial \"%s\" isn't an EBS volume ID", volumeID),
+		}
 	}
 
-	return volumeIDsList
+	return "vol-" + strings.TrimPrefix(volumeID, "vol"), nil
 }
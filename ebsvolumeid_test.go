@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGetEBSVolumesWithoutDeviceFiles(t *testing.T) {
+	originalDevPath := *hostDevPath
+	*hostDevPath = t.TempDir()
+	t.Cleanup(func() { *hostDevPath = originalDevPath })
+
+	// The loop device is reported as a non-EBS device without a device file.
+	loop := &BlockDevice{Name: "loop0", Kind: DeviceKindLoop, Major: 7}
+	_, err := GetEBSVolumes(&StorageStack{Devices: []*BlockDevice{loop}})
+	var nonEBSDevices *NonEBSDevicesError
+	if !errors.As(err, &nonEBSDevices) || len(nonEBSDevices.Devices) != 1 || nonEBSDevices.Devices[0].Device != "loop0" {
+		t.Errorf("Expected the loop device to be reported as a non-EBS device, got %v", err)
+	}
+
+	// The device file of a possible EBS volume is required.
+	disk := &BlockDevice{Name: "xvdf", Kind: DeviceKindXVDDisk, Major: 202, Minor: 80}
+	_, err = GetEBSVolumes(&StorageStack{Devices: []*BlockDevice{disk}})
+	if err == nil || !strings.Contains(err.Error(), "of the disk 202:80 doesn't exist") {
+		t.Errorf("Expected the error about the missing device file, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandExecutor runs external commands and returns their combined output.
+// Tests can replace commandExecutor with a fake implementation.
+type CommandExecutor interface {
+	Execute(name string, arguments ...string) (string, error)
+}
+
+// execCommandExecutor runs commands with os/exec. The binaries have to be
+// available in the image or on the host when running chrooted.
+type execCommandExecutor struct{}
+
+func (execCommandExecutor) Execute(name string, arguments ...string) (string, error) {
+	commandLine := strings.TrimSpace(name + " " + strings.Join(arguments, " "))
+	log.Debugf("Executing \"%s\"...", commandLine)
+
+	output, err := exec.Command(name, arguments...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("\"%s\" failed: %w: %s", commandLine, err, strings.TrimSpace(string(output)))
+	}
+
+	log.Debugf("Output of \"%s\": %s", commandLine, strings.TrimSpace(string(output)))
+	return string(output), nil
+}
+
+// commandExecutor is used to run storage management tools such as dmsetup
+// and the LVM tools.
+var commandExecutor CommandExecutor = execCommandExecutor{}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeCommandExecutor records the executed commands and returns the output
+// of the command line from Outputs. Commands missing there succeed with an
+// empty output, unless Missing lists them, then they fail as if they weren't
+// installed.
+type fakeCommandExecutor struct {
+	Outputs  map[string]string
+	Missing  map[string]bool
+	Commands []string
+}
+
+func (executor *fakeCommandExecutor) Execute(name string, arguments ...string) (string, error) {
+	commandLine := strings.TrimSpace(name + " " + strings.Join(arguments, " "))
+	executor.Commands = append(executor.Commands, commandLine)
+
+	if executor.Missing[name] {
+		return "", fmt.Errorf("\"%s\" failed: %w", commandLine, exec.ErrNotFound)
+	}
+	return executor.Outputs[commandLine], nil
+}
+
+// useCommandExecutor replaces commandExecutor with a fake one for the
+// duration of the test.
+func useCommandExecutor(t *testing.T, outputs map[string]string) *fakeCommandExecutor {
+	executor := &fakeCommandExecutor{Outputs: outputs, Missing: make(map[string]bool)}
+	originalExecutor := commandExecutor
+	commandExecutor = executor
+	t.Cleanup(func() { commandExecutor = originalExecutor })
+	return executor
+}
+
+func checkCommands(t *testing.T, executor *fakeCommandExecutor, expected ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(executor.Commands, expected) {
+		t.Errorf("Expected commands %q, got %q", expected, executor.Commands)
+	}
+}
+
+func TestMDGrowthPlanApply(t *testing.T) {
+	executor := useCommandExecutor(t, nil)
+
+	plan := &MDGrowthPlan{Array: "/dev/md0", Level: "raid1"}
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, executor, "mdadm --grow /dev/md0 --size=max")
+}
+
+func TestResizeCryptLayers(t *testing.T) {
+	executor := useCommandExecutor(t, nil)
+
+	layers := []*BlockDevice{{MapperName: "inner"}, {MapperName: "outer"}}
+	if err := ResizeCryptLayers(layers); err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, executor, "cryptsetup resize inner", "cryptsetup resize outer")
+}
+
+func TestRunCooldownCommand(t *testing.T) {
+	executor := useCommandExecutor(t, map[string]string{
+		"/bin/cleanup --keep-days 3 vol-0123456789abcdef0 2021-06-01T18:00:00Z": "Removed 2 files",
+	})
+
+	output, err := runCooldownCommand(" /bin/cleanup  --keep-days 3 ", "vol-0123456789abcdef0", "2021-06-01T18:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "Removed 2 files" {
+		t.Errorf("Unexpected output \"%s\"", output)
+	}
+	checkCommands(t, executor, "/bin/cleanup --keep-days 3 vol-0123456789abcdef0 2021-06-01T18:00:00Z")
+
+	if _, err := runCooldownCommand("  ", "vol-0123456789abcdef0", "2021-06-01T18:00:00Z"); err == nil {
+		t.Error("Expected an error for the empty command")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// inodeBoundFilesystems are filesystems with a fixed number of inodes per
+// byte, so the number of inodes only grows together with the size.
+var inodeBoundFilesystems = map[string]bool{
+	"ext2": true,
+	"ext3": true,
+	"ext4": true,
+}
+
+// FreeSpaceTarget is the free space a filesystem has to have after the
+// enlargement. Percent is the share of the space available to unprivileged
+// users, as df counts it, and also applies to inodes of inode-bound
+// filesystems. Zero values aren't applied.
+type FreeSpaceTarget struct {
+	Percent int64
+	Bytes   int64
+}
+
+// Validate returns an error if the target is out of range.
+func (target FreeSpaceTarget) Validate() error {
+	if target.Percent < 0 || target.Percent > 99 {
+		return fmt.Errorf("Wrong target free percent %d, it must be within 0-99", target.Percent)
+	}
+	if target.Bytes < 0 {
+		return fmt.Errorf("Wrong target free bytes %d", target.Bytes)
+	}
+	return nil
+}
+
+// IsSet returns true if any target is defined.
+func (target FreeSpaceTarget) IsSet() bool {
+	return target.Percent > 0 || target.Bytes > 0
+}
+
+// RequiredGrowth returns the number of bytes the devices of size deviceSize
+// under the filesystem have to grow by to reach the target, or zero if the
+// filesystem already has enough free space. Metadata and the blocks reserved
+// for root are assumed to grow in proportion to the size, as the ratio of
+// the current statfs size to deviceSize and the current share of the
+// reserved blocks.
+func (target FreeSpaceTarget) RequiredGrowth(usage *FilesystemUsage, fsType string, deviceSize int64) (int64, error) {
+	if deviceSize <= 0 || usage.Size <= 0 {
+		return 0, fmt.Errorf("Filesystem overhead can't be measured with the filesystem size %d and the device size %d", usage.Size, deviceSize)
+	}
+
+	// Share of the device space available to users, either free or used.
+	overheadRatio := float64(usage.Size) / float64(deviceSize)
+	userRatio := overheadRatio * float64(usage.Used+usage.Available) / float64(usage.Size)
+	if userRatio <= 0 {
+		return 0, fmt.Errorf("Filesystem has no space available to users")
+	}
+	log.Debugf("Filesystem takes %.2f%% of the devices, %.2f%% is available to users.", overheadRatio*100, userRatio*100)
+
+	requiredSize := float64(deviceSize)
+	require := func(size float64, reason string) {
+		if size > requiredSize {
+			log.Debugf("%s requires %d bytes of devices.", reason, int64(math.Ceil(size)))
+			requiredSize = size
+		}
+	}
+
+	if target.Percent > 0 {
+		freeShare := float64(target.Percent) / 100
+		require(float64(usage.Used)/((1-freeShare)*userRatio), fmt.Sprintf("%d%% of free space", target.Percent))
+		if inodeBoundFilesystems[fsType] && usage.Inodes > 0 {
+			require(float64(deviceSize)*float64(usage.InodesUsed)/((1-freeShare)*float64(usage.Inodes)), fmt.Sprintf("%d%% of free inodes", target.Percent))
+		}
+	}
+	if target.Bytes > 0 {
+		require(float64(usage.Used+target.Bytes)/userRatio, fmt.Sprintf("%d bytes of free space", target.Bytes))
+	}
+
+	return int64(math.Ceil(requiredSize)) - deviceSize, nil
+}
+
+// TargetFreeSpaceIncrement returns the number of GiB every EBS volume of
+// the mounted storage stack has to grow by, so the filesystem reaches the
+// target. For an LVM logical volume it's the increment of the logical
+// volume, which is split between its physical volumes by the LVM plan.
+func TargetFreeSpaceIncrement(stack *StorageStack, target FreeSpaceTarget) (int64, error) {
+	if stack.Mount == nil {
+		return 0, fmt.Errorf("\"%s\" isn't mounted, so its free space can't be measured", stack)
+	}
+
+	usage, err := ReadFilesystemUsage(stack.Mount)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("\"%s\" uses %.1f%% of space and %.1f%% of inodes, %s is available.", stack, usage.UsedPercent(), usage.InodesUsedPercent(), formatSize(usage.Available))
+
+	var deviceSize int64
+	for _, device := range stack.Devices {
+		deviceSize += device.Size
+	}
+
+	growth, err := target.RequiredGrowth(usage, stack.Mount.FSType, deviceSize)
+	if err != nil {
+		return 0, err
+	}
+
+	growth, err = splitGrowth(stack, growth)
+	if err != nil {
+		return 0, err
+	}
+
+	return (growth + GiB - 1) / GiB, nil
+}
+
+// splitGrowth returns the number of bytes every device under the filesystem
+// has to grow by, so the devices of the filesystem grow by growth bytes in
+// total. Every device of a multi-device btrfs filesystem grows by an equal
+// share. All members of an md array grow to the same size, and only the
+// data share of them, e.g. without the parity of raid5, adds to the array.
+func splitGrowth(stack *StorageStack, growth int64) (int64, error) {
+	if devicesNumber := int64(len(stack.Devices)); devicesNumber > 1 {
+		return (growth + devicesNumber - 1) / devicesNumber, nil
+	}
+
+	array := stack.Devices[0]
+	if array.Kind != DeviceKindMD {
+		// Other md arrays can't be grown by the free space target, since
+		// PlanMDGrowth only plans the growth of the filesystem device.
+		if arrays := stack.DevicesOfKind(DeviceKindMD); len(arrays) > 0 {
+			return 0, fmt.Errorf("The free space target can't be split between members of the md array \"%s\" under \"%s\"", arrays[0].Name, stack)
+		}
+		return growth, nil
+	}
+
+	var membersSize int64
+	for _, member := range array.Children {
+		membersSize += member.Size
+	}
+	if array.Size <= 0 || membersSize <= 0 {
+		return 0, fmt.Errorf("Data share of members of the md array \"%s\" can't be measured with the array size %d and the members size %d", array.Name, array.Size, membersSize)
+	}
+
+	// The array grows by the data share of the growth of every member.
+	membersNumber := float64(len(array.Children))
+	dataShare := float64(array.Size) / float64(membersSize)
+	log.Debugf("%.2f%% of members of \"%s\" hold data.", dataShare*100, array.Name)
+
+	return int64(math.Ceil(float64(growth) / (dataShare * membersNumber))), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRequiredGrowth(t *testing.T) {
+	// Filesystems on 100 GiB devices, one of them with half of the device
+	// taken by metadata.
+	usage := &FilesystemUsage{Size: 100 * GiB, Used: 40 * GiB, Available: 60 * GiB, Inodes: 1000, InodesUsed: 800}
+	overheadUsage := &FilesystemUsage{Size: 50 * GiB, Used: 40 * GiB, Available: 10 * GiB}
+
+	tests := []struct {
+		name     string
+		target   FreeSpaceTarget
+		usage    *FilesystemUsage
+		fsType   string
+		expected int64
+	}{
+		{"enough free space", FreeSpaceTarget{Percent: 50}, usage, "xfs", 0},
+		{"percent", FreeSpaceTarget{Percent: 80}, usage, "xfs", 100 * GiB},
+		{"bytes", FreeSpaceTarget{Bytes: 70 * GiB}, usage, "xfs", 10 * GiB},
+		{"inodes", FreeSpaceTarget{Percent: 50}, usage, "ext4", 60 * GiB},
+		{"overhead", FreeSpaceTarget{Bytes: 30 * GiB}, overheadUsage, "xfs", 40 * GiB},
+	}
+
+	for _, test := range tests {
+		growth, err := test.target.RequiredGrowth(test.usage, test.fsType, 100*GiB)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		// Allow rounding errors of the float computation.
+		if difference := growth - test.expected; difference < -1 || difference > 1 {
+			t.Errorf("%s: expected %d bytes, got %d", test.name, test.expected, growth)
+		}
+	}
+}
+
+func TestSplitGrowth(t *testing.T) {
+	member := func(name string) *BlockDevice {
+		return &BlockDevice{Name: name, Kind: DeviceKindNVMeDisk, Size: 100 * GiB}
+	}
+	array := func(level string, size int64, membersNumber int) *BlockDevice {
+		device := &BlockDevice{Name: "md0", Kind: DeviceKindMD, RAIDLevel: level, Size: size}
+		for index := 0; index < membersNumber; index++ {
+			device.Children = append(device.Children, member("nvme1n1"))
+		}
+		return device
+	}
+	crypt := &BlockDevice{Name: "dm-0", Kind: DeviceKindDMCrypt, Size: 200 * GiB, Children: []*BlockDevice{array("raid1", 100*GiB, 2)}}
+
+	tests := []struct {
+		name     string
+		devices  []*BlockDevice
+		expected int64
+		fails    bool
+	}{
+		{"single disk", []*BlockDevice{member("nvme1n1")}, 30 * GiB, false},
+		{"multi-device btrfs", []*BlockDevice{member("nvme1n1"), member("nvme2n1"), member("nvme3n1")}, 10 * GiB, false},
+		{"raid1", []*BlockDevice{array("raid1", 100*GiB, 2)}, 30 * GiB, false},
+		{"raid5", []*BlockDevice{array("raid5", 300*GiB, 4)}, 10 * GiB, false},
+		{"raid6", []*BlockDevice{array("raid6", 300*GiB, 5)}, 10 * GiB, false},
+		{"raid10", []*BlockDevice{array("raid10", 200*GiB, 4)}, 15 * GiB, false},
+		{"md under dm-crypt", []*BlockDevice{crypt}, 0, true},
+	}
+
+	for _, test := range tests {
+		growth, err := splitGrowth(&StorageStack{Devices: test.devices}, 30*GiB)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %d bytes", test.name, growth)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if growth != test.expected {
+			t.Errorf("%s: expected %d bytes, got %d", test.name, test.expected, growth)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// ext4IocResizeFS is _IOW('f', 16, __u64).
+	ext4IocResizeFS = 0x40086610
+	// xfsIocFSGeometry is _IOR('X', 126, struct xfs_fsop_geom).
+	xfsIocFSGeometry = 0x8100587E
+	// xfsIocFSGeometryV1 is _IOR('X', 100, struct xfs_fsop_geom_v1), which
+	// is used by kernels older than 5.2.
+	xfsIocFSGeometryV1 = 0x80705864
+	// xfsIocFSGrowFSData is _IOW('X', 110, struct xfs_growfs_data).
+	xfsIocFSGrowFSData = 0x4010586E
+)
+
+// xfsFSGeometry is struct xfs_fsop_geom from xfs/xfs_fs.h. The first fields
+// match struct xfs_fsop_geom_v1.
+type xfsFSGeometry struct {
+	BlockSize    uint32
+	RTExtSize    uint32
+	AGBlocks     uint32
+	AGCount      uint32
+	LogBlocks    uint32
+	SectSize     uint32
+	InodeSize    uint32
+	IMaxPct      uint32
+	DataBlocks   uint64
+	RTBlocks     uint64
+	RTExtents    uint64
+	LogStart     uint64
+	UUID         [16]byte
+	SUnit        uint32
+	SWidth       uint32
+	Version      int32
+	Flags        uint32
+	LogSectSize  uint32
+	RTSectSize   uint32
+	DirBlockSize uint32
+	LogSUnit     uint32
+	Sick         uint32
+	Checked      uint32
+	Reserved     [17]uint64
+}
+
+// xfsGrowFSData is struct xfs_growfs_data from xfs/xfs_fs.h.
+type xfsGrowFSData struct {
+	NewBlocks uint64
+	IMaxPct   uint32
+}
+
+// filesystemResizers maps filesystem types to functions growing mounted
+// filesystems of that type.
+var filesystemResizers = map[string]func(mount *MountInfo) error{
+	"ext4":  resizeExt4,
+	"xfs":   resizeXFS,
+	"btrfs": resizeBtrfs,
+}
+
+// CheckFilesystemResizable returns an error if the mounted filesystem can't
+// be grown online.
+func CheckFilesystemResizable(mount *MountInfo) error {
+	if _, ok := filesystemResizers[mount.FSType]; !ok {
+		return fmt.Errorf("Online resize of %s filesystem mounted on \"%s\" isn't supported", mount.FSType, mount.MountPoint)
+	}
+
+	return nil
+}
+
+// ResizeFilesystem grows the mounted filesystem online to the size of its
+// block devices. ext4, XFS and btrfs are supported.
+func ResizeFilesystem(mount *MountInfo) error {
+	resize, ok := filesystemResizers[mount.FSType]
+	if !ok {
+		return fmt.Errorf("Online resize of %s filesystem mounted on \"%s\" isn't supported", mount.FSType, mount.MountPoint)
+	}
+
+	return resize(mount)
+}
+
+// resizeExt4 grows the mounted ext4 filesystem with the EXT4_IOC_RESIZE_FS
+// ioctl, like resize2fs does for mounted filesystems. statfs reports the
+// blocks count without the metadata overhead, so it's lower than the blocks
+// count of the filesystem, but it changes when the filesystem grows.
+func resizeExt4(mount *MountInfo) error {
+	deviceSysPath := filepath.Join(*hostSysPath, "dev", "block", fmt.Sprintf("%d:%d", mount.Major, mount.Minor))
+	deviceSize, err := readBlockDeviceSize(deviceSysPath)
+	if err != nil {
+		return err
+	}
+
+	mountPoint := namespacePath(mount.MountPoint)
+
+	var filesystemStat syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &filesystemStat); err != nil {
+		return fmt.Errorf("Couldn't get filesystem statistics of \"%s\": %s", mount.MountPoint, err)
+	}
+
+	blockSize := int64(filesystemStat.Bsize)
+	blocksCount := filesystemStat.Blocks
+	newBlocksCount := uint64(deviceSize / blockSize)
+
+	if newBlocksCount <= blocksCount {
+		log.Infof("ext4 filesystem mounted on \"%s\" already fills the device: %d blocks.", mount.MountPoint, blocksCount)
+		return nil
+	}
+
+	log.Infof("Resizing ext4 filesystem on \"%s\" to %d blocks of %d bytes...", mount.Source, newBlocksCount, blockSize)
+
+	directory, err := os.Open(mountPoint)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), ext4IocResizeFS, uintptr(unsafe.Pointer(&newBlocksCount)))
+	if errno != 0 {
+		return fmt.Errorf("Couldn't resize ext4 filesystem mounted on \"%s\": %s", mount.MountPoint, errno)
+	}
+
+	if err := syscall.Statfs(mountPoint, &filesystemStat); err != nil {
+		return fmt.Errorf("Couldn't get filesystem statistics of \"%s\": %s", mount.MountPoint, err)
+	}
+	if filesystemStat.Blocks == blocksCount {
+		log.Infof("ext4 filesystem mounted on \"%s\" already fills the device: %d blocks.", mount.MountPoint, blocksCount)
+		return nil
+	}
+
+	log.Infof("ext4 filesystem mounted on \"%s\" was grown from %d to %d blocks.", mount.MountPoint, blocksCount, filesystemStat.Blocks)
+	return nil
+}
+
+// resizeXFS grows the data section of the mounted XFS filesystem with the
+// XFS_IOC_FSGEOMETRY and XFS_IOC_FSGROWFSDATA ioctls, like xfs_growfs does.
+func resizeXFS(mount *MountInfo) error {
+	deviceSysPath := filepath.Join(*hostSysPath, "dev", "block", fmt.Sprintf("%d:%d", mount.Major, mount.Minor))
+	deviceSize, err := readBlockDeviceSize(deviceSysPath)
+	if err != nil {
+		return err
+	}
+
+	directory, err := os.Open(namespacePath(mount.MountPoint))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+
+	geometry, err := readXFSGeometry(directory)
+	if err != nil {
+		return fmt.Errorf("Couldn't get XFS geometry of the filesystem mounted on \"%s\": %s", mount.MountPoint, err)
+	}
+	log.Debugf("XFS geometry of the filesystem mounted on \"%s\": %+v", mount.MountPoint, *geometry)
+
+	growData := xfsGrowFSData{
+		NewBlocks: uint64(deviceSize) / uint64(geometry.BlockSize),
+		IMaxPct:   geometry.IMaxPct,
+	}
+
+	if growData.NewBlocks <= geometry.DataBlocks {
+		log.Infof("XFS filesystem mounted on \"%s\" already fills the device: %d data blocks.", mount.MountPoint, geometry.DataBlocks)
+		return nil
+	}
+
+	log.Infof("Growing XFS filesystem on \"%s\" from %d to %d data blocks of %d bytes...", mount.Source, geometry.DataBlocks, growData.NewBlocks, geometry.BlockSize)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), xfsIocFSGrowFSData, uintptr(unsafe.Pointer(&growData)))
+	if errno != 0 {
+		return fmt.Errorf("Couldn't grow XFS filesystem mounted on \"%s\": %s", mount.MountPoint, errno)
+	}
+
+	// The filesystem may round the size down to the allocation group layout.
+	geometry, err = readXFSGeometry(directory)
+	if err != nil {
+		return fmt.Errorf("Couldn't get XFS geometry of the filesystem mounted on \"%s\": %s", mount.MountPoint, err)
+	}
+	log.Infof("XFS filesystem mounted on \"%s\" was grown to %d data blocks.", mount.MountPoint, geometry.DataBlocks)
+
+	return nil
+}
+
+// readXFSGeometry gets the geometry of the XFS filesystem containing the
+// opened directory. Kernels older than 5.2 only support the v1 structure.
+func readXFSGeometry(directory *os.File) (*xfsFSGeometry, error) {
+	geometry := &xfsFSGeometry{}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), xfsIocFSGeometry, uintptr(unsafe.Pointer(geometry)))
+	if errno == syscall.ENOTTY {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, directory.Fd(), xfsIocFSGeometryV1, uintptr(unsafe.Pointer(geometry)))
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return geometry, nil
+}
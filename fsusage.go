@@ -0,0 +1,54 @@
+package main
+
+import (
+	"syscall"
+)
+
+// FilesystemUsage is the usage of a mounted filesystem as statfs reports it.
+// Sizes are in bytes. Available is the space available to unprivileged
+// users, so it excludes the blocks reserved for root.
+type FilesystemUsage struct {
+	Size       int64 `json:"size"`
+	Used       int64 `json:"used"`
+	Available  int64 `json:"available"`
+	Inodes     int64 `json:"inodes"`
+	InodesUsed int64 `json:"inodesUsed"`
+	InodesFree int64 `json:"inodesFree"`
+}
+
+// ReadFilesystemUsage gets the usage of the mounted filesystem with statfs.
+func ReadFilesystemUsage(mount *MountInfo) (*FilesystemUsage, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(namespacePath(mount.MountPoint), &statfs); err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(statfs.Bsize)
+
+	return &FilesystemUsage{
+		Size:       int64(statfs.Blocks) * blockSize,
+		Used:       int64(statfs.Blocks-statfs.Bfree) * blockSize,
+		Available:  int64(statfs.Bavail) * blockSize,
+		Inodes:     int64(statfs.Files),
+		InodesUsed: int64(statfs.Files - statfs.Ffree),
+		InodesFree: int64(statfs.Ffree),
+	}, nil
+}
+
+// UsedPercent returns the share of the used space in percent, counting the
+// reserved blocks as df does.
+func (usage *FilesystemUsage) UsedPercent() float64 {
+	if usage.Used+usage.Available == 0 {
+		return 0
+	}
+	return float64(usage.Used) * 100 / float64(usage.Used+usage.Available)
+}
+
+// InodesUsedPercent returns the share of the used inodes in percent. Some
+// filesystems, e.g. btrfs, report no inodes at all.
+func (usage *FilesystemUsage) InodesUsedPercent() float64 {
+	if usage.Inodes == 0 {
+		return 0
+	}
+	return float64(usage.InodesUsed) * 100 / float64(usage.Inodes)
+}
@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/aws/aws-sdk-go v1.38.30
-	github.com/kubernetes-csi/external-snapshotter/client/v4 v4.0.0 // indirect
+	github.com/kubernetes-csi/external-snapshotter/client/v4 v4.0.0
 	github.com/sirupsen/logrus v1.8.1
 	k8s.io/api v0.21.0
 	k8s.io/apimachinery v0.21.0
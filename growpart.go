@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// blkGetSize64 is _IOR(0x12, 114, size_t).
+	blkGetSize64 = 0x80081272
+	// blkSSZGet is _IO(0x12, 104).
+	blkSSZGet = 0x1268
+	// blkPG is _IO(0x12, 105).
+	blkPG = 0x1269
+	// blkPGResizePartition is the BLKPG_RESIZE_PARTITION operation.
+	blkPGResizePartition = 3
+
+	defaultSectorSize = 512
+
+	mbrPartitionTableOffset = 446
+	mbrPartitionEntrySize   = 16
+	mbrPartitionsNumber     = 4
+	mbrSignatureOffset      = 510
+	mbrProtectiveType       = 0xEE
+	maxMBRSectors           = 0xFFFFFFFF
+
+	gptSignature = "EFI PART"
+)
+
+// mbrExtendedTypes lists MBR partition types of extended partitions.
+var mbrExtendedTypes = map[byte]bool{0x05: true, 0x0F: true, 0x85: true}
+
+// blkpgPartition is struct blkpg_partition from linux/blkpg.h.
+type blkpgPartition struct {
+	Start   int64
+	Length  int64
+	PNo     int32
+	DevName [64]byte
+	VolName [64]byte
+}
+
+// blkpgIoctlArg is struct blkpg_ioctl_arg from linux/blkpg.h.
+type blkpgIoctlArg struct {
+	Op      int32
+	Flags   int32
+	DataLen int32
+	Data    unsafe.Pointer
+}
+
+// partitionedDisk is a block device or a disk image file with a partition table.
+type partitionedDisk struct {
+	file        *os.File
+	path        string
+	sectorSize  int64
+	size        int64
+	blockDevice bool
+	// readOnly disks are only checked, growing their partitions stops before
+	// anything is written.
+	readOnly bool
+}
+
+// openPartitionedDisk opens the disk for reading, and for writing unless
+// it's read-only, and gets its size. The logical sector size of image files
+// is considered 512 bytes.
+func openPartitionedDisk(path string, readOnly bool) (*partitionedDisk, error) {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	disk := &partitionedDisk{
+		file:       file,
+		path:       path,
+		sectorSize: defaultSectorSize,
+		readOnly:   readOnly,
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if fileInfo.Mode()&os.ModeDevice == 0 {
+		disk.size = fileInfo.Size()
+		return disk, nil
+	}
+
+	disk.blockDevice = true
+
+	var size uint64
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("Couldn't get the size of \"%s\": %s", path, errno)
+	}
+	disk.size = int64(size)
+
+	var sectorSize int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), blkSSZGet, uintptr(unsafe.Pointer(&sectorSize))); errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("Couldn't get the sector size of \"%s\": %s", path, errno)
+	}
+	disk.sectorSize = int64(sectorSize)
+
+	return disk, nil
+}
+
+func (disk *partitionedDisk) Close() error {
+	return disk.file.Close()
+}
+
+// sectors returns the number of sectors of the disk.
+func (disk *partitionedDisk) sectors() int64 {
+	return disk.size / disk.sectorSize
+}
+
+func (disk *partitionedDisk) readAt(offset, length int64) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := disk.file.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("Couldn't read %d bytes at %d from \"%s\": %s", length, offset, disk.path, err)
+	}
+	return data, nil
+}
+
+func (disk *partitionedDisk) writeAt(data []byte, offset int64) error {
+	if _, err := disk.file.WriteAt(data, offset); err != nil {
+		return fmt.Errorf("Couldn't write %d bytes at %d to \"%s\": %s", len(data), offset, disk.path, err)
+	}
+	return nil
+}
+
+// GrowPartition extends the partition with the given number to the end of
+// the disk, like the growpart tool does. GPT and MBR partition tables are
+// supported. The partition has to be the last one on the disk. The disk can
+// be a block device or a disk image file. For block devices the kernel is
+// notified about the new partition size.
+func GrowPartition(diskPath string, partitionNumber int) error {
+	disk, err := openPartitionedDisk(diskPath, false)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	firstLBA, oldLastLBA, newLastLBA, err := disk.growPartition(partitionNumber)
+	if err != nil {
+		return err
+	}
+
+	if newLastLBA == oldLastLBA {
+		log.Infof("Partition %d of \"%s\" already fills the disk.", partitionNumber, diskPath)
+		return nil
+	}
+
+	if err := disk.file.Sync(); err != nil {
+		return err
+	}
+
+	log.Infof("Partition %d of \"%s\" was extended from %d to %d sectors.", partitionNumber, diskPath, oldLastLBA-firstLBA+1, newLastLBA-firstLBA+1)
+
+	if !disk.blockDevice {
+		return nil
+	}
+
+	return resizeKernelPartition(disk, partitionNumber, firstLBA*disk.sectorSize, (newLastLBA-firstLBA+1)*disk.sectorSize)
+}
+
+// CheckPartitionGrowable returns an error if GrowPartition can't extend the
+// partition, e.g. if it isn't the last one on the disk, it's an extended or a
+// logical MBR partition, or the partition table is damaged. The disk is only
+// read, so it's checked before the volume is enlarged.
+func CheckPartitionGrowable(diskPath string, partitionNumber int) error {
+	disk, err := openPartitionedDisk(diskPath, true)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	_, _, _, err = disk.growPartition(partitionNumber)
+	return err
+}
+
+// growPartition reads the partition table and extends the partition in it.
+// Read-only disks are left unchanged.
+func (disk *partitionedDisk) growPartition(partitionNumber int) (firstLBA, oldLastLBA, newLastLBA int64, err error) {
+	mbr, err := disk.readAt(0, disk.sectorSize)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if mbr[mbrSignatureOffset] != 0x55 || mbr[mbrSignatureOffset+1] != 0xAA {
+		return 0, 0, 0, fmt.Errorf("No partition table found on \"%s\"", disk.path)
+	}
+
+	if mbr[mbrPartitionTableOffset+4] == mbrProtectiveType {
+		return growGPTPartition(disk, mbr, partitionNumber)
+	}
+	return growMBRPartition(disk, mbr, partitionNumber)
+}
+
+// growMBRPartition extends a primary MBR partition. MBR can't address more
+// than 2^32 sectors, the rest of the disk stays unused.
+func growMBRPartition(disk *partitionedDisk, mbr []byte, partitionNumber int) (firstLBA, oldLastLBA, newLastLBA int64, err error) {
+	if partitionNumber < 1 || partitionNumber > mbrPartitionsNumber {
+		return 0, 0, 0, fmt.Errorf("Only primary MBR partitions can be extended, got partition %d", partitionNumber)
+	}
+
+	entryOffset := mbrPartitionTableOffset + (partitionNumber-1)*mbrPartitionEntrySize
+	entry := mbr[entryOffset : entryOffset+mbrPartitionEntrySize]
+	partitionType := entry[4]
+	if partitionType == 0 {
+		return 0, 0, 0, fmt.Errorf("Partition %d doesn't exist on \"%s\"", partitionNumber, disk.path)
+	}
+	if mbrExtendedTypes[partitionType] {
+		return 0, 0, 0, fmt.Errorf("Partition %d on \"%s\" is an extended partition", partitionNumber, disk.path)
+	}
+
+	firstLBA = int64(binary.LittleEndian.Uint32(entry[8:12]))
+	oldLastLBA = firstLBA + int64(binary.LittleEndian.Uint32(entry[12:16])) - 1
+
+	for number := 1; number <= mbrPartitionsNumber; number++ {
+		otherOffset := mbrPartitionTableOffset + (number-1)*mbrPartitionEntrySize
+		other := mbr[otherOffset : otherOffset+mbrPartitionEntrySize]
+		if number == partitionNumber || other[4] == 0 {
+			continue
+		}
+		if int64(binary.LittleEndian.Uint32(other[8:12])) > firstLBA {
+			return 0, 0, 0, fmt.Errorf("Partition %d isn't the last one on \"%s\"", partitionNumber, disk.path)
+		}
+	}
+
+	newSize := disk.sectors() - firstLBA
+	if newSize > maxMBRSectors {
+		log.Warnf("MBR partition table can't address the whole \"%s\".", disk.path)
+		newSize = maxMBRSectors
+	}
+	newLastLBA = firstLBA + newSize - 1
+
+	if newLastLBA <= oldLastLBA {
+		return firstLBA, oldLastLBA, oldLastLBA, nil
+	}
+	if disk.readOnly {
+		return firstLBA, oldLastLBA, newLastLBA, nil
+	}
+
+	binary.LittleEndian.PutUint32(entry[12:16], uint32(newSize))
+	// The end of the partition doesn't fit CHS addressing anymore.
+	copy(entry[5:8], []byte{0xFE, 0xFF, 0xFF})
+
+	if err := disk.writeAt(mbr[mbrPartitionTableOffset:mbrSignatureOffset], mbrPartitionTableOffset); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return firstLBA, oldLastLBA, newLastLBA, nil
+}
+
+// growGPTPartition moves the backup GPT header and partition entries to the
+// end of the disk and extends the partition to the new last usable sector.
+func growGPTPartition(disk *partitionedDisk, mbr []byte, partitionNumber int) (firstLBA, oldLastLBA, newLastLBA int64, err error) {
+	header, err := disk.readAt(disk.sectorSize, disk.sectorSize)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if string(header[0:8]) != gptSignature {
+		return 0, 0, 0, fmt.Errorf("No GPT header found on \"%s\"", disk.path)
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	if headerSize < 92 || int64(headerSize) > disk.sectorSize {
+		return 0, 0, 0, fmt.Errorf("Wrong GPT header size %d on \"%s\"", headerSize, disk.path)
+	}
+	if gptHeaderChecksum(header[:headerSize]) != binary.LittleEndian.Uint32(header[16:20]) {
+		return 0, 0, 0, fmt.Errorf("GPT header checksum mismatch on \"%s\"", disk.path)
+	}
+
+	oldLastUsableLBA := int64(binary.LittleEndian.Uint64(header[48:56]))
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	entriesNumber := int64(binary.LittleEndian.Uint32(header[80:84]))
+	entrySize := int64(binary.LittleEndian.Uint32(header[84:88]))
+	if entrySize < 128 {
+		return 0, 0, 0, fmt.Errorf("Wrong GPT partition entry size %d on \"%s\"", entrySize, disk.path)
+	}
+
+	entries, err := disk.readAt(entriesLBA*disk.sectorSize, entriesNumber*entrySize)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if crc32.ChecksumIEEE(entries) != binary.LittleEndian.Uint32(header[88:92]) {
+		return 0, 0, 0, fmt.Errorf("GPT partition entries checksum mismatch on \"%s\"", disk.path)
+	}
+
+	if partitionNumber < 1 || int64(partitionNumber) > entriesNumber {
+		return 0, 0, 0, fmt.Errorf("Partition %d doesn't exist on \"%s\"", partitionNumber, disk.path)
+	}
+
+	entry := entries[int64(partitionNumber-1)*entrySize : int64(partitionNumber)*entrySize]
+	if bytes.Equal(entry[0:16], make([]byte, 16)) {
+		return 0, 0, 0, fmt.Errorf("Partition %d doesn't exist on \"%s\"", partitionNumber, disk.path)
+	}
+	firstLBA = int64(binary.LittleEndian.Uint64(entry[32:40]))
+	oldLastLBA = int64(binary.LittleEndian.Uint64(entry[40:48]))
+
+	for index := int64(0); index < entriesNumber; index++ {
+		other := entries[index*entrySize : (index+1)*entrySize]
+		if index == int64(partitionNumber-1) || bytes.Equal(other[0:16], make([]byte, 16)) {
+			continue
+		}
+		if int64(binary.LittleEndian.Uint64(other[40:48])) > oldLastLBA {
+			return 0, 0, 0, fmt.Errorf("Partition %d isn't the last one on \"%s\"", partitionNumber, disk.path)
+		}
+	}
+
+	// The backup partition entries are located right before the backup
+	// header in the last sector of the disk.
+	entriesSectors := (entriesNumber*entrySize + disk.sectorSize - 1) / disk.sectorSize
+	backupHeaderLBA := disk.sectors() - 1
+	backupEntriesLBA := backupHeaderLBA - entriesSectors
+	newLastLBA = backupEntriesLBA - 1
+
+	if newLastLBA < oldLastUsableLBA {
+		return 0, 0, 0, fmt.Errorf("\"%s\" is smaller than its GPT partition table", disk.path)
+	}
+	if newLastLBA <= oldLastLBA {
+		return firstLBA, oldLastLBA, oldLastLBA, nil
+	}
+	if disk.readOnly {
+		return firstLBA, oldLastLBA, newLastLBA, nil
+	}
+
+	binary.LittleEndian.PutUint64(entry[40:48], uint64(newLastLBA))
+	entriesChecksum := crc32.ChecksumIEEE(entries)
+
+	binary.LittleEndian.PutUint64(header[32:40], uint64(backupHeaderLBA))
+	binary.LittleEndian.PutUint64(header[48:56], uint64(newLastLBA))
+	binary.LittleEndian.PutUint32(header[88:92], entriesChecksum)
+	binary.LittleEndian.PutUint32(header[16:20], gptHeaderChecksum(header[:headerSize]))
+
+	backupHeader := make([]byte, len(header))
+	copy(backupHeader, header)
+	binary.LittleEndian.PutUint64(backupHeader[24:32], uint64(backupHeaderLBA))
+	binary.LittleEndian.PutUint64(backupHeader[32:40], 1)
+	binary.LittleEndian.PutUint64(backupHeader[72:80], uint64(backupEntriesLBA))
+	binary.LittleEndian.PutUint32(backupHeader[16:20], gptHeaderChecksum(backupHeader[:headerSize]))
+
+	// Write the backup copy first, so the primary header never points to
+	// a missing backup.
+	if err := disk.writeAt(entries, backupEntriesLBA*disk.sectorSize); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := disk.writeAt(backupHeader, backupHeaderLBA*disk.sectorSize); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := disk.writeAt(entries, entriesLBA*disk.sectorSize); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := disk.writeAt(header, disk.sectorSize); err != nil {
+		return 0, 0, 0, err
+	}
+
+	// The protective MBR partition covers the whole disk.
+	protectiveSize := disk.sectors() - 1
+	if protectiveSize > maxMBRSectors {
+		protectiveSize = maxMBRSectors
+	}
+	binary.LittleEndian.PutUint32(mbr[mbrPartitionTableOffset+12:mbrPartitionTableOffset+16], uint32(protectiveSize))
+	if err := disk.writeAt(mbr[mbrPartitionTableOffset:mbrPartitionTableOffset+mbrPartitionEntrySize], mbrPartitionTableOffset); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return firstLBA, oldLastLBA, newLastLBA, nil
+}
+
+// gptHeaderChecksum calculates CRC32 of the GPT header with the zeroed
+// checksum field.
+func gptHeaderChecksum(header []byte) uint32 {
+	data := make([]byte, len(header))
+	copy(data, header)
+	binary.LittleEndian.PutUint32(data[16:20], 0)
+	return crc32.ChecksumIEEE(data)
+}
+
+// resizeKernelPartition tells the kernel the new size of the partition with
+// the BLKPG ioctl, so the partition can be extended while it's in use.
+func resizeKernelPartition(disk *partitionedDisk, partitionNumber int, start, length int64) error {
+	partition := blkpgPartition{
+		Start:  start,
+		Length: length,
+		PNo:    int32(partitionNumber),
+	}
+	argument := blkpgIoctlArg{
+		Op:      blkPGResizePartition,
+		DataLen: int32(unsafe.Sizeof(partition)),
+		Data:    unsafe.Pointer(&partition),
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, disk.file.Fd(), blkPG, uintptr(unsafe.Pointer(&argument)))
+	if errno != 0 {
+		return fmt.Errorf("Couldn't resize partition %d of \"%s\" in the kernel: %s", partitionNumber, disk.path, errno)
+	}
+
+	log.Infof("The kernel uses the new size of partition %d of \"%s\".", partitionNumber, disk.path)
+	return nil
+}
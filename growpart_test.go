@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testGPTEntriesNumber  = 128
+	testGPTEntrySize      = 128
+	testGPTEntriesSectors = testGPTEntriesNumber * testGPTEntrySize / defaultSectorSize
+)
+
+// testPartition is the first and the last sector of a partition.
+type testPartition struct {
+	first int64
+	last  int64
+}
+
+// createDiskImage creates an image file of the size in sectors with the
+// partition table written by write, then enlarges it to grownSectors, as
+// the EBS volume enlargement does.
+func createDiskImage(t *testing.T, sectors, grownSectors int64, write func(image *os.File, sectors int64)) string {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	image, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer image.Close()
+
+	if err := image.Truncate(sectors * defaultSectorSize); err != nil {
+		t.Fatal(err)
+	}
+	write(image, sectors)
+	if err := image.Truncate(grownSectors * defaultSectorSize); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func writeMBR(t *testing.T, image *os.File, entries [mbrPartitionsNumber][16]byte) {
+	mbr := make([]byte, defaultSectorSize)
+	for index, entry := range entries {
+		copy(mbr[mbrPartitionTableOffset+index*mbrPartitionEntrySize:], entry[:])
+	}
+	mbr[mbrSignatureOffset] = 0x55
+	mbr[mbrSignatureOffset+1] = 0xAA
+	if _, err := image.WriteAt(mbr, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mbrEntry(partitionType byte, first, sectors int64) [16]byte {
+	var entry [16]byte
+	entry[4] = partitionType
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(first))
+	binary.LittleEndian.PutUint32(entry[12:16], uint32(sectors))
+	return entry
+}
+
+// writeGPT writes a protective MBR, the primary and the backup GPT headers
+// and partition entries, as sgdisk does.
+func writeGPT(t *testing.T, image *os.File, sectors int64, partitions []testPartition) {
+	writeMBR(t, image, [mbrPartitionsNumber][16]byte{mbrEntry(mbrProtectiveType, 1, sectors-1)})
+
+	entries := make([]byte, testGPTEntriesNumber*testGPTEntrySize)
+	for index, partition := range partitions {
+		entry := entries[index*testGPTEntrySize:]
+		copy(entry[0:16], "linux-data-guid!")
+		copy(entry[16:32], []byte{byte(index + 1)})
+		binary.LittleEndian.PutUint64(entry[32:40], uint64(partition.first))
+		binary.LittleEndian.PutUint64(entry[40:48], uint64(partition.last))
+	}
+
+	backupHeaderLBA := sectors - 1
+	backupEntriesLBA := backupHeaderLBA - testGPTEntriesSectors
+	header := make([]byte, defaultSectorSize)
+	copy(header[0:8], gptSignature)
+	binary.LittleEndian.PutUint32(header[8:12], 0x00010000)
+	binary.LittleEndian.PutUint32(header[12:16], 92)
+	binary.LittleEndian.PutUint64(header[24:32], 1)
+	binary.LittleEndian.PutUint64(header[32:40], uint64(backupHeaderLBA))
+	binary.LittleEndian.PutUint64(header[40:48], 2+testGPTEntriesSectors)
+	binary.LittleEndian.PutUint64(header[48:56], uint64(backupEntriesLBA-1))
+	binary.LittleEndian.PutUint64(header[72:80], 2)
+	binary.LittleEndian.PutUint32(header[80:84], testGPTEntriesNumber)
+	binary.LittleEndian.PutUint32(header[84:88], testGPTEntrySize)
+	binary.LittleEndian.PutUint32(header[88:92], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:20], gptHeaderChecksum(header[:92]))
+
+	backupHeader := make([]byte, defaultSectorSize)
+	copy(backupHeader, header)
+	binary.LittleEndian.PutUint64(backupHeader[24:32], uint64(backupHeaderLBA))
+	binary.LittleEndian.PutUint64(backupHeader[32:40], 1)
+	binary.LittleEndian.PutUint64(backupHeader[72:80], uint64(backupEntriesLBA))
+	binary.LittleEndian.PutUint32(backupHeader[16:20], gptHeaderChecksum(backupHeader[:92]))
+
+	for offset, data := range map[int64][]byte{
+		defaultSectorSize:                    header,
+		2 * defaultSectorSize:                entries,
+		backupEntriesLBA * defaultSectorSize: entries,
+		backupHeaderLBA * defaultSectorSize:  backupHeader,
+	} {
+		if _, err := image.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readImage(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkGPTHeader checks the checksums of the header at the LBA and of its
+// partition entries, and returns the header.
+func checkGPTHeader(t *testing.T, image []byte, lba int64) []byte {
+	header := image[lba*defaultSectorSize : (lba+1)*defaultSectorSize]
+	if string(header[0:8]) != gptSignature {
+		t.Fatalf("No GPT header at LBA %d", lba)
+	}
+	if gptHeaderChecksum(header[:92]) != binary.LittleEndian.Uint32(header[16:20]) {
+		t.Errorf("GPT header checksum mismatch at LBA %d", lba)
+	}
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	entries := image[entriesLBA*defaultSectorSize : entriesLBA*defaultSectorSize+testGPTEntriesNumber*testGPTEntrySize]
+	if crc32.ChecksumIEEE(entries) != binary.LittleEndian.Uint32(header[88:92]) {
+		t.Errorf("GPT partition entries checksum mismatch for the header at LBA %d", lba)
+	}
+	return header
+}
+
+func gptPartitionLastLBA(image []byte, header []byte, partitionNumber int) int64 {
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	offset := entriesLBA*defaultSectorSize + int64(partitionNumber-1)*testGPTEntrySize
+	return int64(binary.LittleEndian.Uint64(image[offset+40 : offset+48]))
+}
+
+func TestGrowGPTPartition(t *testing.T) {
+	const sectors, grownSectors = 8192, 32768
+	path := createDiskImage(t, sectors, grownSectors, func(image *os.File, sectors int64) {
+		writeGPT(t, image, sectors, []testPartition{{2048, 4095}, {4096, sectors - 34}})
+	})
+
+	if err := GrowPartition(path, 1); err == nil || !strings.Contains(err.Error(), "isn't the last one") {
+		t.Errorf("Expected the error about not the last partition, got %v", err)
+	}
+	if err := GrowPartition(path, 3); err == nil {
+		t.Error("Expected an error for the missing partition")
+	}
+
+	if err := GrowPartition(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	image := readImage(t, path)
+
+	backupHeaderLBA := int64(grownSectors - 1)
+	backupEntriesLBA := backupHeaderLBA - testGPTEntriesSectors
+	expectedLastLBA := backupEntriesLBA - 1
+
+	primary := checkGPTHeader(t, image, 1)
+	if alternateLBA := int64(binary.LittleEndian.Uint64(primary[32:40])); alternateLBA != backupHeaderLBA {
+		t.Errorf("Expected the backup header at LBA %d, the primary header points to %d", backupHeaderLBA, alternateLBA)
+	}
+	if lastUsableLBA := int64(binary.LittleEndian.Uint64(primary[48:56])); lastUsableLBA != expectedLastLBA {
+		t.Errorf("Expected the last usable LBA %d, got %d", expectedLastLBA, lastUsableLBA)
+	}
+	if lastLBA := gptPartitionLastLBA(image, primary, 2); lastLBA != expectedLastLBA {
+		t.Errorf("Expected partition 2 to end at LBA %d, got %d", expectedLastLBA, lastLBA)
+	}
+	if lastLBA := gptPartitionLastLBA(image, primary, 1); lastLBA != 4095 {
+		t.Errorf("Partition 1 was changed to end at LBA %d", lastLBA)
+	}
+
+	backup := checkGPTHeader(t, image, backupHeaderLBA)
+	if myLBA, alternateLBA := binary.LittleEndian.Uint64(backup[24:32]), binary.LittleEndian.Uint64(backup[32:40]); int64(myLBA) != backupHeaderLBA || alternateLBA != 1 {
+		t.Errorf("Wrong LBAs of the backup header: %d and %d", myLBA, alternateLBA)
+	}
+	if entriesLBA := int64(binary.LittleEndian.Uint64(backup[72:80])); entriesLBA != backupEntriesLBA {
+		t.Errorf("Expected the backup partition entries at LBA %d, got %d", backupEntriesLBA, entriesLBA)
+	}
+	if lastLBA := gptPartitionLastLBA(image, backup, 2); lastLBA != expectedLastLBA {
+		t.Errorf("Expected partition 2 to end at LBA %d in the backup entries, got %d", expectedLastLBA, lastLBA)
+	}
+
+	if protectiveSize := binary.LittleEndian.Uint32(image[mbrPartitionTableOffset+12 : mbrPartitionTableOffset+16]); protectiveSize != grownSectors-1 {
+		t.Errorf("Expected the protective MBR partition of %d sectors, got %d", grownSectors-1, protectiveSize)
+	}
+
+	// Growing the partition again changes nothing.
+	if err := GrowPartition(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, readImage(t, path)) {
+		t.Error("Growing the grown partition changed the image")
+	}
+}
+
+func TestGrowGPTPartitionChecksumMismatch(t *testing.T) {
+	path := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {
+		writeGPT(t, image, sectors, []testPartition{{2048, sectors - 34}})
+		// Corrupt the partition entries.
+		if _, err := image.WriteAt([]byte{0xFF}, 2*defaultSectorSize+testGPTEntrySize); err != nil {
+			t.Fatal(err)
+		}
+	})
+	original := readImage(t, path)
+
+	if err := GrowPartition(path, 1); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected the checksum mismatch error, got %v", err)
+	}
+	if !bytes.Equal(original, readImage(t, path)) {
+		t.Error("The image with the corrupted partition table was changed")
+	}
+}
+
+func TestGrowMBRPartition(t *testing.T) {
+	const sectors, grownSectors = 8192, 32768
+	path := createDiskImage(t, sectors, grownSectors, func(image *os.File, sectors int64) {
+		writeMBR(t, image, [mbrPartitionsNumber][16]byte{
+			mbrEntry(0x83, 2048, 2048),
+			mbrEntry(0x83, 4096, sectors-4096),
+		})
+	})
+
+	if err := GrowPartition(path, 1); err == nil || !strings.Contains(err.Error(), "isn't the last one") {
+		t.Errorf("Expected the error about not the last partition, got %v", err)
+	}
+	if err := GrowPartition(path, 5); err == nil {
+		t.Error("Expected an error for the logical partition")
+	}
+
+	if err := GrowPartition(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	image := readImage(t, path)
+
+	entry := image[mbrPartitionTableOffset+mbrPartitionEntrySize : mbrPartitionTableOffset+2*mbrPartitionEntrySize]
+	if first, size := binary.LittleEndian.Uint32(entry[8:12]), binary.LittleEndian.Uint32(entry[12:16]); first != 4096 || size != grownSectors-4096 {
+		t.Errorf("Expected partition 2 of %d sectors at 4096, got %d sectors at %d", grownSectors-4096, size, first)
+	}
+	if size := binary.LittleEndian.Uint32(image[mbrPartitionTableOffset+12 : mbrPartitionTableOffset+16]); size != 2048 {
+		t.Errorf("Partition 1 was changed to %d sectors", size)
+	}
+	if image[mbrSignatureOffset] != 0x55 || image[mbrSignatureOffset+1] != 0xAA {
+		t.Error("MBR signature was overwritten")
+	}
+
+	// Growing the partition again changes nothing.
+	if err := GrowPartition(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, readImage(t, path)) {
+		t.Error("Growing the grown partition changed the image")
+	}
+}
+
+func TestGrowMBRExtendedPartition(t *testing.T) {
+	path := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {
+		writeMBR(t, image, [mbrPartitionsNumber][16]byte{
+			mbrEntry(0x83, 2048, 2048),
+			mbrEntry(0x05, 4096, sectors-4096),
+		})
+	})
+
+	if err := GrowPartition(path, 2); err == nil || !strings.Contains(err.Error(), "extended partition") {
+		t.Errorf("Expected the error about the extended partition, got %v", err)
+	}
+}
+
+func TestGrowPartitionWithoutPartitionTable(t *testing.T) {
+	path := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {})
+
+	if err := GrowPartition(path, 1); err == nil || !strings.Contains(err.Error(), "No partition table") {
+		t.Errorf("Expected the error about the missing partition table, got %v", err)
+	}
+}
+
+func TestCheckPartitionGrowable(t *testing.T) {
+	mbrPath := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {
+		writeMBR(t, image, [mbrPartitionsNumber][16]byte{
+			mbrEntry(0x83, 2048, 2048),
+			mbrEntry(0x83, 4096, sectors-4096),
+		})
+	})
+	extendedPath := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {
+		writeMBR(t, image, [mbrPartitionsNumber][16]byte{
+			mbrEntry(0x83, 2048, 2048),
+			mbrEntry(0x05, 4096, sectors-4096),
+		})
+	})
+	gptPath := createDiskImage(t, 8192, 16384, func(image *os.File, sectors int64) {
+		writeGPT(t, image, sectors, []testPartition{{2048, 4095}, {4096, sectors - 34}})
+	})
+
+	tests := []struct {
+		name            string
+		path            string
+		partitionNumber int
+		err             string
+	}{
+		{"last MBR partition", mbrPath, 2, ""},
+		{"not the last MBR partition", mbrPath, 1, "isn't the last one"},
+		{"logical MBR partition", mbrPath, 5, "Only primary MBR partitions"},
+		{"extended MBR partition", extendedPath, 2, "extended partition"},
+		{"last GPT partition", gptPath, 2, ""},
+		{"not the last GPT partition", gptPath, 1, "isn't the last one"},
+	}
+
+	originals := make(map[string][]byte)
+	for _, path := range []string{mbrPath, extendedPath, gptPath} {
+		originals[path] = readImage(t, path)
+	}
+
+	for _, test := range tests {
+		err := CheckPartitionGrowable(test.path, test.partitionNumber)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected the error \"%s\", got %v", test.name, test.err, err)
+		}
+	}
+
+	for path, original := range originals {
+		if !bytes.Equal(original, readImage(t, path)) {
+			t.Errorf("Checking the partitions changed \"%s\"", path)
+		}
+	}
+}
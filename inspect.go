@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// InspectReport describes the storage stack of a filesystem or a device.
+type InspectReport struct {
+	Filesystem *InspectedFilesystem `json:"filesystem,omitempty"`
+	Devices    []*InspectedDevice   `json:"devices"`
+}
+
+// InspectedFilesystem is the mounted filesystem on top of the stack.
+type InspectedFilesystem struct {
+	MountPoint string `json:"mountPoint"`
+	FSType     string `json:"fsType"`
+	Source     string `json:"source"`
+}
+
+// InspectedDevice is a block device of the stack. Leaves of the stack have
+// the EBS volume or the error explaining why it wasn't found.
+type InspectedDevice struct {
+	Name            string              `json:"name"`
+	Kind            DeviceKind          `json:"kind"`
+	MajorMinor      string              `json:"majorMinor"`
+	Size            int64               `json:"size"`
+	PartitionNumber int                 `json:"partitionNumber,omitempty"`
+	MapperName      string              `json:"mapperName,omitempty"`
+	RAIDLevel       string              `json:"raidLevel,omitempty"`
+	BackingFile     string              `json:"backingFile,omitempty"`
+	Model           string              `json:"model,omitempty"`
+	Storage         DiskStorage         `json:"storage,omitempty"`
+	EBSVolume       *InspectedEBSVolume `json:"ebsVolume,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	Children        []*InspectedDevice  `json:"children,omitempty"`
+}
+
+// InspectedEBSVolume is the EBS volume of a disk as DescribeVolumes and
+// DescribeVolumesModifications report it. Size is in GiB, Throughput is in
+// MiB/s.
+type InspectedEBSVolume struct {
+	VolumeID             string `json:"volumeId"`
+	VolumeType           string `json:"volumeType,omitempty"`
+	Size                 int64  `json:"size,omitempty"`
+	Iops                 int64  `json:"iops,omitempty"`
+	Throughput           int64  `json:"throughput,omitempty"`
+	State                string `json:"state,omitempty"`
+	ModificationState    string `json:"modificationState,omitempty"`
+	ModificationProgress int64  `json:"modificationProgress,omitempty"`
+}
+
+// Inspect prints the storage stack of the target, which is a block device
+// file or any path inside a mounted filesystem. The format is tree, which is
+// the default, or json.
+func Inspect(target string, format string, output io.Writer) error {
+	var stack *StorageStack
+	var err error
+	if fileInfo, statErr := os.Stat(target); statErr == nil && fileInfo.Mode()&os.ModeDevice == os.ModeDevice {
+		stack, err = BuildDeviceStorageStack(target)
+	} else {
+		stack, err = BuildStorageStack(target)
+	}
+	if err != nil {
+		return err
+	}
+
+	report := InspectStorageStack(stack)
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "tree", "":
+		report.WriteTree(output)
+		return nil
+	default:
+		return fmt.Errorf("Wrong output format \"%s\" of the inspect command, one of: [tree, json]", format)
+	}
+}
+
+// InspectStorageStack describes the storage stack and its EBS volumes. If
+// the EBS volumes can't be described, only their IDs are reported.
+func InspectStorageStack(stack *StorageStack) *InspectReport {
+	report := &InspectReport{}
+	if stack.Mount != nil {
+		report.Filesystem = &InspectedFilesystem{
+			MountPoint: stack.Mount.MountPoint,
+			FSType:     stack.Mount.FSType,
+			Source:     stack.Mount.Source,
+		}
+	}
+
+	inspected := make(map[*BlockDevice]*InspectedDevice)
+	var volumeIDsList []string
+	stack.Walk(func(device *BlockDevice) error {
+		node := &InspectedDevice{
+			Name:            device.Name,
+			Kind:            device.Kind,
+			MajorMinor:      device.MajorMinor(),
+			Size:            device.Size,
+			PartitionNumber: device.PartitionNumber,
+			MapperName:      device.MapperName,
+			RAIDLevel:       device.RAIDLevel,
+			BackingFile:     device.BackingFile,
+			Model:           device.Model,
+		}
+		for _, child := range device.Children {
+			node.Children = append(node.Children, inspected[child])
+		}
+
+		if len(device.Children) == 0 {
+			node.Storage, _ = classifyDevice(device)
+			volumeID, err := getEBSVolumeID(device)
+			var nonEBSDevice *NonEBSDeviceError
+			if errors.As(err, &nonEBSDevice) {
+				node.Storage = nonEBSDevice.Storage
+			}
+			if err != nil {
+				node.Error = err.Error()
+			} else {
+				node.EBSVolume = &InspectedEBSVolume{VolumeID: volumeID}
+				volumeIDsList = append(volumeIDsList, volumeID)
+			}
+		}
+
+		inspected[device] = node
+		return nil
+	})
+
+	for _, device := range stack.Devices {
+		report.Devices = append(report.Devices, inspected[device])
+	}
+
+	if len(volumeIDsList) == 0 {
+		return report
+	}
+
+	volumes, err := describeEBSVolumes(volumeIDsList)
+	if err != nil {
+		log.Warnln("Couldn't describe the EBS volumes:", err)
+		return report
+	}
+	for _, node := range inspected {
+		if node.EBSVolume == nil {
+			continue
+		}
+		if volume, ok := volumes[node.EBSVolume.VolumeID]; ok {
+			node.EBSVolume = volume
+		}
+	}
+
+	return report
+}
+
+// describeEBSVolumes gets the attributes and the latest modification state
+// of the EBS volumes.
+func describeEBSVolumes(volumeIDsList []string) (map[string]*InspectedEBSVolume, error) {
+	svc, err := newEC2Client()
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]*InspectedEBSVolume)
+	err = svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{
+		VolumeIds: aws.StringSlice(volumeIDsList),
+	}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			volumes[aws.StringValue(volume.VolumeId)] = &InspectedEBSVolume{
+				VolumeID:   aws.StringValue(volume.VolumeId),
+				VolumeType: aws.StringValue(volume.VolumeType),
+				Size:       aws.Int64Value(volume.Size),
+				Iops:       aws.Int64Value(volume.Iops),
+				Throughput: aws.Int64Value(volume.Throughput),
+				State:      aws.StringValue(volume.State),
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = describeVolumesModifications(svc, volumeIDsList, func(modification *ec2.VolumeModification) {
+		volume, ok := volumes[aws.StringValue(modification.VolumeId)]
+		if !ok {
+			return
+		}
+		volume.ModificationState = aws.StringValue(modification.ModificationState)
+		volume.ModificationProgress = aws.Int64Value(modification.Progress)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return volumes, nil
+}
+
+// WriteTree writes the stack from the filesystem down to the EBS volumes,
+// like lsblk --inverse does.
+func (report *InspectReport) WriteTree(output io.Writer) {
+	if report.Filesystem != nil {
+		fmt.Fprintf(output, "%s %s %s\n", report.Filesystem.MountPoint, report.Filesystem.FSType, report.Filesystem.Source)
+	} else {
+		fmt.Fprintln(output, "(not mounted)")
+	}
+	writeTreeNodes(output, "", report.Devices)
+}
+
+func writeTreeNodes(output io.Writer, prefix string, nodes []*InspectedDevice) {
+	for index, node := range nodes {
+		branch, indent := "├─ ", "│  "
+		if index == len(nodes)-1 {
+			branch, indent = "└─ ", "   "
+		}
+
+		fmt.Fprintf(output, "%s%s%s\n", prefix, branch, node.describe())
+
+		switch {
+		case node.EBSVolume != nil:
+			fmt.Fprintf(output, "%s%s└─ %s\n", prefix, indent, node.EBSVolume.describe())
+		case node.Error != "":
+			fmt.Fprintf(output, "%s%s└─ error: %s\n", prefix, indent, node.Error)
+		}
+
+		writeTreeNodes(output, prefix+indent, node.Children)
+	}
+}
+
+func (node *InspectedDevice) describe() string {
+	fields := []string{node.Name, string(node.Kind), node.MajorMinor, formatSize(node.Size)}
+	if node.PartitionNumber != 0 {
+		fields = append(fields, fmt.Sprintf("partition %d", node.PartitionNumber))
+	}
+	if node.MapperName != "" {
+		fields = append(fields, node.MapperName)
+	}
+	if node.RAIDLevel != "" {
+		fields = append(fields, node.RAIDLevel)
+	}
+	if node.BackingFile != "" {
+		fields = append(fields, node.BackingFile)
+	}
+	if node.Storage != "" {
+		fields = append(fields, string(node.Storage))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (volume *InspectedEBSVolume) describe() string {
+	fields := []string{volume.VolumeID}
+	if volume.VolumeType != "" {
+		fields = append(fields, volume.VolumeType, fmt.Sprintf("%d GiB", volume.Size))
+	}
+	if volume.Iops != 0 {
+		fields = append(fields, fmt.Sprintf("%d IOPS", volume.Iops))
+	}
+	if volume.Throughput != 0 {
+		fields = append(fields, fmt.Sprintf("%d MiB/s", volume.Throughput))
+	}
+	if volume.State != "" {
+		fields = append(fields, volume.State)
+	}
+	if volume.ModificationState != "" {
+		fields = append(fields, fmt.Sprintf("modification %s %d%%", volume.ModificationState, volume.ModificationProgress))
+	}
+	return strings.Join(fields, " ")
+}
+
+// formatSize formats the number of bytes with binary units.
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
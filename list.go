@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// EBSMount is a filesystem located on EBS volumes or an EBS volume without
+// a mounted filesystem.
+type EBSMount struct {
+	// MountPoint, FSType, Source and Usage are empty for volumes which
+	// aren't mounted.
+	MountPoint string           `json:"mountPoint,omitempty"`
+	FSType     string           `json:"fsType,omitempty"`
+	Source     string           `json:"source,omitempty"`
+	Usage      *FilesystemUsage `json:"usage,omitempty"`
+	// Devices are the names of the disks, VolumeIDs are their EBS volumes.
+	Devices   []string `json:"devices"`
+	VolumeIDs []string `json:"volumeIds"`
+}
+
+// ListEBSMounts finds every disk in sysfs whose serial is an EBS volume ID
+// and maps the disks to the mounted filesystems located on them. Volumes
+// without mounted filesystems are listed after the mounted ones.
+func ListEBSMounts() ([]EBSMount, error) {
+	volumes, err := findEBSDisks()
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := ReadMountInfo(mountInfoPath())
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one mount of every device is listed.
+	var deviceMounts []MountInfo
+	for _, mount := range mounts {
+		if strings.HasPrefix(mount.Source, "/dev/") {
+			deviceMounts = append(deviceMounts, mount)
+		}
+	}
+	mountsList := DeviceMounts(deviceMounts)
+
+	ebsMounts := []EBSMount{}
+	mounted := make(map[string]bool)
+	for _, mount := range mountsList {
+		stack, err := buildMountStorageStack(mount)
+		if err != nil {
+			log.Debugf("Couldn't read the storage stack of \"%s\": %s", mount.MountPoint, err)
+			continue
+		}
+
+		ebsMount := EBSMount{
+			MountPoint: mount.MountPoint,
+			FSType:     mount.FSType,
+			Source:     mount.Source,
+		}
+		for _, disk := range stack.Disks() {
+			volumeID, ok := volumes[disk.Name]
+			if !ok {
+				continue
+			}
+			ebsMount.Devices = append(ebsMount.Devices, disk.Name)
+			ebsMount.VolumeIDs = append(ebsMount.VolumeIDs, volumeID)
+			mounted[disk.Name] = true
+		}
+		if len(ebsMount.VolumeIDs) == 0 {
+			continue
+		}
+
+		if ebsMount.Usage, err = ReadFilesystemUsage(mount); err != nil {
+			log.Warnf("Couldn't get the usage of \"%s\": %s", mount.MountPoint, err)
+		}
+
+		ebsMounts = append(ebsMounts, ebsMount)
+	}
+
+	var unmountedList []string
+	for device := range volumes {
+		if !mounted[device] {
+			unmountedList = append(unmountedList, device)
+		}
+	}
+	sort.Strings(unmountedList)
+	for _, device := range unmountedList {
+		ebsMounts = append(ebsMounts, EBSMount{
+			Devices:   []string{device},
+			VolumeIDs: []string{volumes[device]},
+		})
+	}
+
+	return ebsMounts, nil
+}
+
+// findEBSDisks returns the EBS volume IDs of the disks in sysfs. Disks are
+// recognized by their serials, NVMe disks reporting the EBS model without
+// a complete serial are identified with the NVMe identify command.
+func findEBSDisks() (map[string]string, error) {
+	classPath := filepath.Join(*hostSysPath, "class", "block")
+	devices, err := ioutil.ReadDir(classPath)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]string)
+	for _, device := range devices {
+		deviceSysPath, err := filepath.EvalSymlinks(filepath.Join(classPath, device.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if partition, err := readPartition(deviceSysPath); err != nil || partition != nil {
+			continue
+		}
+
+		serial, _ := readSysfsAttribute(filepath.Join(deviceSysPath, "device", "serial"))
+		model, _ := readSysfsAttribute(filepath.Join(deviceSysPath, "device", "model"))
+		switch {
+		case ebsSerialRegexp.MatchString(serial):
+			volumes[device.Name()] = "vol-" + strings.TrimPrefix(serial, "vol")
+		case model == ebsNVMeModel:
+			disk, err := ReadBlockDevice(deviceSysPath)
+			if err != nil {
+				return nil, err
+			}
+			volumeID, err := getEBSVolumeID(disk)
+			if err != nil {
+				log.Warnln(err)
+				continue
+			}
+			volumes[device.Name()] = volumeID
+		default:
+			continue
+		}
+		log.Debugf("Device \"%s\" is the EBS volume %s.", device.Name(), volumes[device.Name()])
+	}
+
+	return volumes, nil
+}
+
+// List prints the EBS-backed filesystems of the node. The format is table,
+// which is the default, or json.
+func List(format string, output io.Writer) error {
+	ebsMounts, err := ListEBSMounts()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ebsMounts)
+	case "table", "":
+		writeEBSMountsTable(output, ebsMounts)
+		return nil
+	default:
+		return fmt.Errorf("Wrong output format \"%s\" of the list command, one of: [table, json]", format)
+	}
+}
+
+func writeEBSMountsTable(output io.Writer, ebsMounts []EBSMount) {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MOUNT POINT\tFS\tDEVICES\tVOLUMES\tSIZE\tUSED\tAVAIL\tUSE%\tINODES\tIUSE%")
+	for _, ebsMount := range ebsMounts {
+		mountPoint, fsType := ebsMount.MountPoint, ebsMount.FSType
+		if mountPoint == "" {
+			mountPoint, fsType = "-", "-"
+		}
+		usage := []string{"-", "-", "-", "-", "-", "-"}
+		if ebsMount.Usage != nil {
+			usage = []string{
+				formatSize(ebsMount.Usage.Size),
+				formatSize(ebsMount.Usage.Used),
+				formatSize(ebsMount.Usage.Available),
+				fmt.Sprintf("%.0f%%", ebsMount.Usage.UsedPercent()),
+				fmt.Sprintf("%d", ebsMount.Usage.Inodes),
+				fmt.Sprintf("%.0f%%", ebsMount.Usage.InodesUsedPercent()),
+			}
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", mountPoint, fsType,
+			strings.Join(ebsMount.Devices, ","), strings.Join(ebsMount.VolumeIDs, ","), strings.Join(usage, "\t"))
+	}
+	writer.Flush()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lvmUUIDPrefix is the prefix of device mapper UUIDs of LVM logical volumes.
+const lvmUUIDPrefix = "LVM-"
+
+// dmTarget is a line of a device mapper table. Start and Length are in
+// 512-byte sectors.
+type dmTarget struct {
+	Start     int64
+	Length    int64
+	Type      string
+	Arguments []string
+}
+
+// dmStripe is a device used by a linear or striped target. Device is the
+// major:minor number of the device.
+type dmStripe struct {
+	Device string
+	Offset int64
+}
+
+// LVMGrowthPlan describes how to grow a logical volume and the EBS volumes
+// under it.
+type LVMGrowthPlan struct {
+	// LogicalVolume is the device mapper path of the logical volume, e.g.
+	// /dev/mapper/vg0-data.
+	LogicalVolume string
+	// Increment is the number of bytes the logical volume grows by.
+	Increment int64
+	// PhysicalVolumes lists the devices the logical volume is extended on,
+	// e.g. /dev/nvme1n1.
+	PhysicalVolumes []string
+	// VolumeGrowth maps IDs of EBS volumes to the number of GB they have to
+	// grow by. EBS volumes missing there don't have to grow.
+	VolumeGrowth map[string]int64
+}
+
+// ParseDMTable parses the output of "dmsetup table <name>".
+func ParseDMTable(table string) ([]dmTarget, error) {
+	var targets []dmTarget
+
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Wrong device mapper table line \"%s\"", line)
+		}
+
+		start, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong start of device mapper target \"%s\"", line)
+		}
+		length, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong length of device mapper target \"%s\"", line)
+		}
+
+		targets = append(targets, dmTarget{
+			Start:     start,
+			Length:    length,
+			Type:      fields[2],
+			Arguments: fields[3:],
+		})
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("Empty device mapper table")
+	}
+
+	return targets, nil
+}
+
+// stripes returns the devices of linear and striped targets. Arguments of
+// the linear target are "<device> <offset>", arguments of the striped one
+// are "<stripes> <chunk size> <device> <offset>...".
+func (target dmTarget) stripes() ([]dmStripe, error) {
+	var devices []string
+
+	switch target.Type {
+	case "linear":
+		devices = target.Arguments
+		if len(devices) != 2 {
+			return nil, fmt.Errorf("Wrong arguments of linear target: %v", target.Arguments)
+		}
+	case "striped":
+		if len(target.Arguments) < 2 {
+			return nil, fmt.Errorf("Wrong arguments of striped target: %v", target.Arguments)
+		}
+		stripesNumber, err := strconv.Atoi(target.Arguments[0])
+		if err != nil || len(target.Arguments) != 2+2*stripesNumber {
+			return nil, fmt.Errorf("Wrong arguments of striped target: %v", target.Arguments)
+		}
+		devices = target.Arguments[2:]
+	default:
+		return nil, fmt.Errorf("Device mapper target type \"%s\" isn't supported", target.Type)
+	}
+
+	var stripes []dmStripe
+	for index := 0; index < len(devices); index += 2 {
+		offset, err := strconv.ParseInt(devices[index+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong offset of %s target: %v", target.Type, target.Arguments)
+		}
+		stripes = append(stripes, dmStripe{Device: devices[index], Offset: offset})
+	}
+
+	return stripes, nil
+}
+
+// planLVMGrowth computes how many bytes every physical volume has to grow
+// by, so the logical volume can be extended by the increment. LVM appends
+// new extents to the last segment, so a linear logical volume is extended
+// on the physical volume of its last segment only, and a striped one is
+// extended equally on all stripes of its last segment. The result maps
+// major:minor numbers of physical volumes to the growth in bytes.
+func planLVMGrowth(targets []dmTarget, increment int64) (map[string]int64, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("Empty device mapper table")
+	}
+
+	last := targets[0]
+	for _, target := range targets[1:] {
+		if target.Start > last.Start {
+			last = target
+		}
+	}
+
+	stripes, err := last.stripes()
+	if err != nil {
+		return nil, err
+	}
+
+	stripesNumber := int64(len(stripes))
+	perStripe := (increment + stripesNumber - 1) / stripesNumber
+
+	growth := make(map[string]int64)
+	for _, stripe := range stripes {
+		growth[stripe.Device] += perStripe
+	}
+
+	return growth, nil
+}
+
+// PlanLVMGrowth returns the growth plan if the storage stack is built on a
+// linear or striped LVM logical volume, otherwise nil. The logical volume
+// grows as the sizing policy specifies, and only the EBS volumes needed for
+// that grow. Logical volumes, which can't be planned, e.g. thin ones or ones
+// on md arrays, have no plan. errNoGrowth is returned if the logical volume
+// doesn't have to grow.
+func PlanLVMGrowth(stack *StorageStack, volumes []EBSVolume, sizing *SizingPolicy) (*LVMGrowthPlan, error) {
+	if len(stack.Devices) != 1 || !stack.Devices[0].IsLogicalVolume() {
+		return nil, nil
+	}
+	logicalVolume := stack.Devices[0]
+	log.Infof("\"%s\" is located on the LVM logical volume \"%s\".", stack, logicalVolume.MapperName)
+
+	// Only linear and striped logical volumes are extended on known
+	// physical volumes, other layouts, e.g. thin or raid ones, are left to
+	// the sizing policy.
+	if logicalVolume.Kind != DeviceKindDMLinear {
+		log.Warnf("The logical volume \"%s\" isn't a linear or striped one, so every EBS volume under it grows as the sizing policy specifies.", logicalVolume.MapperName)
+		return nil, nil
+	}
+
+	table, err := commandExecutor.Execute("dmsetup", "table", logicalVolume.MapperName)
+	if errors.Is(err, exec.ErrNotFound) {
+		log.Warnln("dmsetup isn't available, so every EBS volume under the logical volume grows as the sizing policy specifies.")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	targets, err := ParseDMTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &LVMGrowthPlan{
+		LogicalVolume: filepath.Join(*hostDevPath, "mapper", logicalVolume.MapperName),
+		Increment:     sizing.Increment(logicalVolume.Size/GiB) * GiB,
+		VolumeGrowth:  make(map[string]int64),
+	}
+
+	if plan.Increment <= 0 {
+		log.Infof("Logical volume \"%s\" of %d GB doesn't have to grow.", logicalVolume.MapperName, logicalVolume.Size/GiB)
+		return nil, errNoGrowth
+	}
+
+	growth, err := planLVMGrowth(targets, plan.Increment)
+	if err != nil {
+		log.Warnf("%s, so every EBS volume under the logical volume \"%s\" grows as the sizing policy specifies.", err, logicalVolume.MapperName)
+		return nil, nil
+	}
+
+	var physicalVolumesList []string
+	for device := range growth {
+		physicalVolumesList = append(physicalVolumesList, device)
+	}
+	sort.Strings(physicalVolumesList)
+
+	for _, device := range physicalVolumesList {
+		physicalVolume := logicalVolume.findChild(device)
+		if physicalVolume == nil {
+			return nil, fmt.Errorf("Physical volume %s of \"%s\" isn't found in sysfs", device, logicalVolume.MapperName)
+		}
+		disks := physicalVolume.Disks()
+		if len(disks) != 1 {
+			log.Warnf("Physical volume %s of \"%s\" spans several disks, so every EBS volume under the logical volume grows as the sizing policy specifies.", device, logicalVolume.MapperName)
+			return nil, nil
+		}
+
+		var volumeID string
+		for _, volume := range volumes {
+			if volume.Device == disks[0].Name {
+				volumeID = volume.VolumeID
+			}
+		}
+		if volumeID == "" {
+			return nil, fmt.Errorf("Physical volume %s of \"%s\" isn't located on an EBS volume", device, logicalVolume.MapperName)
+		}
+
+		plan.PhysicalVolumes = append(plan.PhysicalVolumes, physicalVolume.Path())
+
+		// EBS volumes grow by whole GB.
+		plan.VolumeGrowth[volumeID] += (growth[device] + GiB - 1) / GiB
+		log.Infof("Physical volume \"%s\" has to grow by %d bytes, EBS volume %s by %d GB.", physicalVolume.Path(), growth[device], volumeID, plan.VolumeGrowth[volumeID])
+	}
+
+	return plan, nil
+}
+
+// Apply resizes the physical volumes to the size of their devices with
+// pvresize and extends the logical volume on them with lvextend.
+func (plan *LVMGrowthPlan) Apply() error {
+	for _, physicalVolume := range plan.PhysicalVolumes {
+		log.Infof("Resizing physical volume \"%s\"...", physicalVolume)
+		if _, err := commandExecutor.Execute("pvresize", physicalVolume); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Extending logical volume \"%s\" by %d GB...", plan.LogicalVolume, plan.Increment/GiB)
+	arguments := append([]string{"--size", fmt.Sprintf("+%dg", plan.Increment/GiB), plan.LogicalVolume}, plan.PhysicalVolumes...)
+	if _, err := commandExecutor.Execute("lvextend", arguments...); err != nil {
+		return err
+	}
+
+	log.Infof("Logical volume \"%s\" was extended.", plan.LogicalVolume)
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanLVMGrowthStriped(t *testing.T) {
+	targets, err := ParseDMTable(`0 41943040 linear 259:1 2048
+41943040 20971520 striped 2 128 259:2 2048 259:3 2048
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	growth, err := planLVMGrowth(targets, 10*GiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int64{"259:2": 5 * GiB, "259:3": 5 * GiB}; !reflect.DeepEqual(growth, expected) {
+		t.Errorf("Expected %v, got %v", expected, growth)
+	}
+
+	for _, table := range []string{"", "0 100", "0 100 thin-pool 259:1 0", "0 100 striped 2 128 259:2 2048"} {
+		targets, err := ParseDMTable(table)
+		if err == nil {
+			_, err = planLVMGrowth(targets, GiB)
+		}
+		if err == nil {
+			t.Errorf("\"%s\": expected an error", table)
+		}
+	}
+}
+
+// readTestLogicalVolume returns the storage stack of the logical volume
+// vg0-data of the fake sysfs tree, which has the device mapper table.
+func readTestLogicalVolume(t *testing.T, table string) *StorageStack {
+	useSysPath(t, testSysPath)
+	useCommandExecutor(t, map[string]string{"dmsetup table vg0-data": table})
+
+	deviceSysPath, err := getDeviceNumberSysPath(253, 70000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logicalVolume, err := ReadBlockDevice(deviceSysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &StorageStack{Devices: []*BlockDevice{logicalVolume}}
+}
+
+func TestPlanLVMGrowth(t *testing.T) {
+	stack := readTestLogicalVolume(t, "0 209713152 linear 259:301 2048\n")
+	volumes := []EBSVolume{{VolumeID: "vol-0123456789abcdef0", Device: "nvme7n1"}}
+	executor := useCommandExecutor(t, map[string]string{
+		"dmsetup table vg0-data": "0 209713152 linear 259:301 2048\n",
+	})
+
+	plan, err := PlanLVMGrowth(stack, volumes, &SizingPolicy{Add: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.LogicalVolume != "/dev/mapper/vg0-data" || plan.Increment != 10*GiB {
+		t.Errorf("Expected to extend /dev/mapper/vg0-data by 10 GB, got \"%s\" by %d bytes", plan.LogicalVolume, plan.Increment)
+	}
+	if expected := []string{"/dev/nvme7n1p1"}; !reflect.DeepEqual(plan.PhysicalVolumes, expected) {
+		t.Errorf("Expected physical volumes %v, got %v", expected, plan.PhysicalVolumes)
+	}
+	if expected := map[string]int64{"vol-0123456789abcdef0": 10}; !reflect.DeepEqual(plan.VolumeGrowth, expected) {
+		t.Errorf("Expected volume growth %v, got %v", expected, plan.VolumeGrowth)
+	}
+
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, executor,
+		"dmsetup table vg0-data",
+		"pvresize /dev/nvme7n1p1",
+		"lvextend --size +10g /dev/mapper/vg0-data /dev/nvme7n1p1",
+	)
+}
+
+func TestPlanLVMGrowthFailures(t *testing.T) {
+	stack := readTestLogicalVolume(t, "0 209713152 linear 259:301 2048\n")
+	volumes := []EBSVolume{{VolumeID: "vol-0123456789abcdef0", Device: "nvme7n1"}}
+
+	// The physical volume isn't a device of the logical volume.
+	useCommandExecutor(t, map[string]string{"dmsetup table vg0-data": "0 209713152 linear 259:1 2048\n"})
+	if _, err := PlanLVMGrowth(stack, volumes, &SizingPolicy{Add: 10}); err == nil {
+		t.Error("Expected an error for the unknown physical volume")
+	}
+
+	// The disk of the physical volume isn't an EBS volume.
+	useCommandExecutor(t, map[string]string{"dmsetup table vg0-data": "0 209713152 linear 259:301 2048\n"})
+	if _, err := PlanLVMGrowth(stack, nil, &SizingPolicy{Add: 10}); err == nil {
+		t.Error("Expected an error for the physical volume not on an EBS volume")
+	}
+
+	// The logical volume is already larger than the absolute size.
+	if _, err := PlanLVMGrowth(stack, volumes, &SizingPolicy{Size: 50}); !errors.Is(err, errNoGrowth) {
+		t.Error("Expected an error for the logical volume which doesn't have to grow")
+	}
+
+	// Without dmsetup every EBS volume grows as the sizing policy specifies.
+	executor := useCommandExecutor(t, nil)
+	executor.Missing["dmsetup"] = true
+	if plan, err := PlanLVMGrowth(stack, volumes, &SizingPolicy{Add: 10}); plan != nil || err != nil {
+		t.Errorf("Expected no plan and no error without dmsetup, got %+v and %v", plan, err)
+	}
+}
+
+func TestPlanLVMGrowthFallback(t *testing.T) {
+	volumes := []EBSVolume{{VolumeID: "vol-0123456789abcdef0", Device: "nvme7n1"}}
+
+	// Thin logical volumes aren't extended on known physical volumes.
+	stack := readTestLogicalVolume(t, "0 209713152 thin 253:3 1\n")
+	if kind := stack.Devices[0].Kind; kind != DeviceKindDM {
+		t.Errorf("Expected the thin logical volume to be a %s device, got %s", DeviceKindDM, kind)
+	}
+	if plan, err := PlanLVMGrowth(stack, volumes, &SizingPolicy{Add: 10}); plan != nil || err != nil {
+		t.Errorf("Expected no plan and no error for the thin logical volume, got %+v and %v", plan, err)
+	}
+
+	// The physical volume is an md array of two EBS volumes.
+	array := &BlockDevice{Name: "md0", Kind: DeviceKindMD, Major: 9, Minor: 0, Children: []*BlockDevice{
+		{Name: "nvme7n1", Kind: DeviceKindNVMeDisk},
+		{Name: "nvme8n1", Kind: DeviceKindNVMeDisk},
+	}}
+	logicalVolume := &BlockDevice{Name: "dm-0", Kind: DeviceKindDMLinear, Size: 100 * GiB, MapperName: "vg0-data", MapperUUID: lvmUUIDPrefix + "data", Children: []*BlockDevice{array}}
+	useCommandExecutor(t, map[string]string{"dmsetup table vg0-data": "0 209713152 linear 9:0 2048\n"})
+	if plan, err := PlanLVMGrowth(&StorageStack{Devices: []*BlockDevice{logicalVolume}}, volumes, &SizingPolicy{Add: 10}); plan != nil || err != nil {
+		t.Errorf("Expected no plan and no error for the physical volume on md, got %+v and %v", plan, err)
+	}
+}
@@ -1,32 +1,74 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	hostSysPath      *string        = flag.String("sys-path", "/sys", "sysfs mountpoint.")
 	hostProcPath     *string        = flag.String("proc-path", "/proc", "procfs mountpoint.")
-	mountPoint       *string        = flag.String("mount-point", "", "Mount point of the volume to be enlarged. (required if pvc isn't set)")
-	pvc              *string        = flag.String("pvc", "", "PVC ID of the volume to be enlarged. (required if mount-point isn't set)")
-	pvcNamespace     *string        = flag.String("pvc-namespace", "", "Kubernetes namespace where pvc is located. (required if mount-point isn't set)")
+	hostDevPath      *string        = flag.String("dev-path", "/dev", "devfs mountpoint, where device files and udev symlinks are located.")
+	mountPoint       *string        = flag.String("mount-point", "", "Mount point of the volume to be enlarged or any path inside its filesystem. (one of mount-point, device, fs-uuid, fs-label, volume-id, volume-filter or pvc is required)")
+	targetDevice     *string        = flag.String("device", "", "Device file of the volume to be enlarged, e.g. /dev/nvme2n1. The volume may be unmounted.")
+	fsUUID           *string        = flag.String("fs-uuid", "", "UUID of the filesystem to be enlarged. It's resolved with <dev-path>/disk/by-uuid, so the filesystem may be unmounted.")
+	fsLabel          *string        = flag.String("fs-label", "", "Label of the filesystem to be enlarged. It's resolved with <dev-path>/disk/by-label, so the filesystem may be unmounted.")
+	volumeIDs        *string        = flag.String("volume-id", "", "Comma-separated IDs of EBS volumes to be enlarged. No access to the host is needed.")
+	volumeFilter     *string        = flag.String("volume-filter", "", "Comma-separated DescribeVolumes filters selecting EBS volumes to be enlarged, e.g. tag:team=db,volume-type=gp3. No access to the host is needed.")
+	targetPID        *int           = flag.Int("pid", 0, "PID of the process whose mount namespace is used to resolve mount-point, e.g. a process of another pod. (default is the own mount namespace)")
+	pvc              *string        = flag.String("pvc", "", "PVC ID of the volume to be enlarged.")
+	pvcNamespace     *string        = flag.String("pvc-namespace", "", "Kubernetes namespace where pvc is located. (required if pvc is set)")
 	percents         *int64         = flag.Int64("percents", 20, "By what percentage to increase.")
+	targetSize       *int64         = flag.Int64("size", 0, "Size in GiB to enlarge the volumes to. Overrides percents. (default is to use percents)")
+	addSize          *int64         = flag.Int64("add", 0, "Number of GiB to add to the volumes. Overrides percents. (default is to use percents)")
+	targetFreePct    *int64         = flag.Int64("target-free-percent", 0, "Grow the volumes until this percentage of the filesystem space and of inodes of ext filesystems is free, as statfs reports it. Overrides percents. Requires a mounted filesystem. (default is to use percents)")
+	targetFreeBytes  *int64         = flag.Int64("target-free-bytes", 0, "Grow the volumes until this number of bytes of the filesystem is free, as statfs reports it. Overrides percents. Requires a mounted filesystem. (default is to use percents)")
+	minIncrement     *int64         = flag.Int64("min-increment", 0, "Minimum number of GiB to add to the volumes. (default is no minimum)")
+	maxIncrement     *int64         = flag.Int64("max-increment", 0, "Maximum number of GiB to add to the volumes. (default is no maximum)")
+	sizeStep         *int64         = flag.Int64("step", 0, "Round the new size up to a multiple of this number of GiB, e.g. 50. (default is no rounding)")
 	createSnapshot   *bool          = flag.Bool("snapshot", false, "If true, create a volume snapshot. (default false)")
 	k8sSnapshotClass *string        = flag.String("k8s-snapshot-class", "csi-aws-vsc", "The name of the VolumeSnapshotClass resource, which is used to create snapshots in Kubernetes.")
 	dryRun           *bool          = flag.Bool("dry-run", false, "If true, only show the result without enlarging the volume. (default false)")
 	waitForModifying *bool          = flag.Bool("wait-for-modifying", false, "If true, wait for enlarging the volume to be completed. (default false)")
+	iopsPolicy       *string        = flag.String("iops-policy", IopsPolicyKeep, "How to change provisioned IOPS of gp3, io1 and io2 volumes together with the size. One of: [keep, per-gib] (per-gib keeps the IOPS per GiB ratio)")
+	minIops          *int64         = flag.Int64("min-iops", 0, "Minimum provisioned IOPS of gp3, io1 and io2 volumes after the enlargement. (default is no minimum)")
+	maxIops          *int64         = flag.Int64("max-iops", 0, "Maximum provisioned IOPS of gp3, io1 and io2 volumes after the enlargement. (default is the limit of the volume type)")
+	throughputPolicy *string        = flag.String("throughput-policy", ThroughputPolicyKeep, "How to change throughput of gp3 volumes together with the size. One of: [keep, proportional]")
+	minThroughput    *int64         = flag.Int64("min-throughput", 0, "Minimum throughput of gp3 volumes in MiB/s after the enlargement. (default is no minimum)")
+	maxThroughput    *int64         = flag.Int64("max-throughput", 0, "Maximum throughput of gp3 volumes in MiB/s after the enlargement. (default is the limit of the volume type)")
+	targetVolumeType *string        = flag.String("volume-type", "", "EBS volume type to convert the volumes to during the enlargement. One of: [gp2, gp3, io1, io2, st1, sc1] (default is to keep the type)")
+	cooldownWait     *time.Duration = flag.Duration("cooldown-wait", 0, "How long to wait for the end of the six-hour EBS modification cooldown. If the volumes can't be modified in this time, cooldown-command is run or the program exits with the code 75.")
+	cooldownCommand  *string        = flag.String("cooldown-command", "", "Command to run instead of the enlargement if the volumes are in the EBS modification cooldown. It's split on spaces and run without a shell, with the volume ID and the earliest retry time appended as arguments. The default image has no other binaries, so the command has to be added with a custom image.")
+	ec2Endpoint      *string        = flag.String("ec2-endpoint", "", "Custom EC2 API endpoint URL. (default is the regional endpoint)")
+	imdsEndpoint     *string        = flag.String("imds-endpoint", "", "Custom EC2 instance metadata service endpoint URL. (default is http://169.254.169.254)")
+	resizeFS         *bool          = flag.Bool("resize-filesystem", false, "If true, grow the mounted filesystem online after the volume enlargement. ext4, XFS and btrfs are supported. Implies grow-partition, resize-crypt, grow-md, extend-lvm and wait-for-device for the layers of the storage stack. (default false)")
+	growPartition    *bool          = flag.Bool("grow-partition", false, "If true, extend partitions of the enlarged volumes to the end of the disks. Implied by resize-filesystem, resize-crypt, grow-md and extend-lvm. Implies wait-for-device. (default false)")
+	resizeCryptLayer *bool          = flag.Bool("resize-crypt", false, "If true, resize dm-crypt mappings to the size of the enlarged devices. Requires cryptsetup. Implied by resize-filesystem. Implies grow-partition and wait-for-device. (default false)")
+	growMD           *bool          = flag.Bool("grow-md", false, "If true, grow the md array to the size of its enlarged members. Requires mdadm. Implied by resize-filesystem. Implies grow-partition and wait-for-device. (default false)")
+	extendLVM        *bool          = flag.Bool("extend-lvm", false, "If true, resize LVM physical volumes and extend the logical volume after the volume enlargement. Requires dmsetup, pvresize and lvextend. Implied by resize-filesystem. Implies grow-partition and wait-for-device. (default false)")
+	waitForDevice    *bool          = flag.Bool("wait-for-device", false, "If true, rescan the enlarged disks and wait until the kernel reports their new size. (default false)")
+	deviceTimeout    *time.Duration = flag.Duration("wait-for-device-timeout", 5*time.Minute, "How long to wait until the kernel reports the new size of the enlarged disks.")
+	outputFormat     *string        = flag.String("output", "", "Output format of the inspect and list commands. One of: [tree, json] for inspect, [table, json] for list (default is tree for inspect and table for list)")
 	logLevel         *string        = flag.String("log-level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
 	log              *logrus.Logger = logrus.New()
 	logLevelsList    [4]string      = [4]string{"debug", "info", "warn", "error"}
 	dryRunMessage    string         = "Request would have succeeded, but -dry-run=true flag is set. Exiting..."
 )
 
+// cooldownExitCode is the exit code of runs, which couldn't enlarge volumes
+// because of the EBS modification cooldown. It's EX_TEMPFAIL from sysexits.h.
+const cooldownExitCode = 75
+
 // LogLevelContains looks for defined log level in the logLevelsList
 func LogLevelContains(slice [4]string, value string) (logrus.Level, error) {
 	for _, item := range slice {
@@ -38,16 +80,90 @@ func LogLevelContains(slice [4]string, value string) (logrus.Level, error) {
 	return 0, fmt.Errorf("There was a wrong log level defined: %v", value)
 }
 
+// waitForModificationCooldown waits until none of the EBS volumes to be
+// enlarged is in the modification cooldown. ModifyVolume fails during the
+// cooldown, so no volume is enlarged if any of them is in the cooldown, and
+// the program exits as exitOnCooldown does.
+func waitForModificationCooldown(volumes []EBSVolume) {
+	var volumeIDsList []string
+	for _, volume := range volumes {
+		volumeIDsList = append(volumeIDsList, volume.VolumeID)
+	}
+
+	if err := WaitForModificationCooldown(volumeIDsList, *cooldownWait); err != nil {
+		exitOnCooldown(err)
+	}
+}
+
+// exitOnCooldown reports the volume in the EBS modification cooldown and
+// runs the cooldown command if it's set. Otherwise the program exits with
+// cooldownExitCode. Other errors are fatal.
+func exitOnCooldown(err error) {
+	var cooldownError *CooldownError
+	if !errors.As(err, &cooldownError) {
+		log.Fatalln(err)
+	}
+
+	retryAfter := cooldownError.RetryAfter.Format(time.RFC3339)
+	log.WithFields(logrus.Fields{
+		"result":      "cooldown",
+		"volume-id":   cooldownError.VolumeID,
+		"retry-after": retryAfter,
+	}).Errorln(cooldownError)
+
+	if *cooldownCommand == "" {
+		os.Exit(cooldownExitCode)
+	}
+
+	log.Infoln("Running the cooldown command...")
+	output, err := runCooldownCommand(*cooldownCommand, cooldownError.VolumeID, retryAfter)
+	if output = strings.TrimSpace(output); output != "" {
+		log.Infoln(output)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(0)
+}
+
+// runCooldownCommand runs the cooldown command without a shell, which isn't
+// available in the scratch image. The command is split on spaces, and the
+// volume ID and the retry time are appended as arguments.
+func runCooldownCommand(command, volumeID, retryAfter string) (string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Empty cooldown command")
+	}
+
+	arguments := append(fields[1:], volumeID, retryAfter)
+	return commandExecutor.Execute(fields[0], arguments...)
+}
+
 func init() {
 	log.SetFormatter(&logrus.TextFormatter{
 		DisableColors: true,
 		DisableQuote:  false,
 		FullTimestamp: true,
 	})
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s inspect [flags] <path or device>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s list [flags]\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Flags:")
+		flag.PrintDefaults()
+	}
 }
 
 func main() {
-	flag.Parse()
+	// Commands precede the flags.
+	command := ""
+	arguments := os.Args[1:]
+	if len(arguments) > 0 && (arguments[0] == "inspect" || arguments[0] == "list") {
+		command, arguments = arguments[0], arguments[1:]
+	}
+	flag.CommandLine.Parse(arguments)
 
 	logrusLogLevel, err := LogLevelContains(logLevelsList, *logLevel)
 
@@ -62,21 +178,105 @@ func main() {
 		log.Fatalln("The program only runs on Linux.")
 	}
 
+	switch command {
+	case "list":
+		if err := List(*outputFormat, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	case "inspect":
+		// The target is either the argument or the mount-point flag.
+		target := flag.Arg(0)
+		if target == "" {
+			target = *mountPoint
+		}
+		if target == "" || flag.NArg() > 1 {
+			flag.Usage()
+			log.Fatalln("inspect requires a single path or device.")
+		}
+
+		if err := Inspect(target, *outputFormat, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+
 	// pvcNamespace must be defined if pvc is defined.
 	if *pvc != "" && *pvcNamespace == "" {
 		flag.Usage()
 		log.Fatalln("pvc-namespace must be defined if pvc is defined.")
 	}
 
-	// Check if mountPoint or pvc is defined.
+	sizingPolicy := newSizingPolicy()
+	if err := sizingPolicy.Validate(); err != nil {
+		flag.Usage()
+		log.Fatalln(err)
+	}
+
+	freeSpaceTarget := FreeSpaceTarget{Percent: *targetFreePct, Bytes: *targetFreeBytes}
+	if err := freeSpaceTarget.Validate(); err != nil {
+		flag.Usage()
+		log.Fatalln(err)
+	}
+	if freeSpaceTarget.IsSet() && (sizingPolicy.Size != 0 || sizingPolicy.Add != 0) {
+		flag.Usage()
+		log.Fatalln("target-free-percent and target-free-bytes can't be used with size or add.")
+	}
+
+	performancePolicy := newPerformancePolicy()
+	if err := performancePolicy.Validate(); err != nil {
+		flag.Usage()
+		log.Fatalln(err)
+	}
+
+	if *targetVolumeType != "" && !isConvertibleVolumeType(*targetVolumeType) {
+		flag.Usage()
+		log.Fatalf("Volumes can't be converted to the volume type \"%s\".", *targetVolumeType)
+	}
+
+	// PVCs are resized by the CSI driver, which only changes the size.
+	if *pvc != "" && (*targetVolumeType != "" || *performancePolicy != (PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep})) {
+		flag.Usage()
+		log.Fatalln("volume-type, iops-policy, min-iops, max-iops, throughput-policy, min-throughput and max-throughput can't be used with pvc.")
+	}
+
+	// Host targets are discovered in sysfs, other targets don't need access to the host.
+	hostTarget := *mountPoint != "" || *targetDevice != "" || *fsUUID != "" || *fsLabel != ""
+
+	if *targetPID != 0 && (*targetPID < 0 || !hostTarget) {
+		flag.Usage()
+		log.Fatalln("pid must be a positive number and can only be used with mount-point, device, fs-uuid or fs-label.")
+	}
+
+	if (*waitForDevice || *growPartition || *resizeCryptLayer || *growMD || *extendLVM || *resizeFS) && !hostTarget {
+		flag.Usage()
+		log.Fatalln("wait-for-device, grow-partition, resize-crypt, grow-md, extend-lvm and resize-filesystem can only be used with mount-point, device, fs-uuid or fs-label.")
+	}
+
+	if freeSpaceTarget.IsSet() && !hostTarget {
+		flag.Usage()
+		log.Fatalln("target-free-percent and target-free-bytes can only be used with mount-point, device, fs-uuid or fs-label.")
+	}
+
+	targetsNumber := 0
+	for _, target := range []string{*mountPoint, *targetDevice, *fsUUID, *fsLabel, *volumeIDs, *volumeFilter, *pvc} {
+		if target != "" {
+			targetsNumber++
+		}
+	}
+
+	// Check which target is defined.
 	// Depending on what is defined, run the appropriate function to get volumeIDsList.
-	// If neither or both are defined, throw an error.
+	// If none or several are defined, throw an error.
 	switch {
+	case targetsNumber > 1:
+		flag.Usage()
+		log.Fatalln("Only one of mount-point, device, fs-uuid, fs-label, volume-id, volume-filter and pvc can be defined.")
 	// If -pvc is specified, increase the PVC size.
-	case *mountPoint == "" && *pvc != "":
+	case *pvc != "":
 		log.Infof("-pvc=%s is specified. Increasing PVC size...", *pvc)
 
-		err := EnlargePVC(*pvc, *pvcNamespace, percents, createSnapshot, dryRun, waitForModifying)
+		err := EnlargePVC(*pvc, *pvcNamespace, sizingPolicy, createSnapshot, dryRun, waitForModifying)
 		if err != nil {
 			if err.Error() == "DryRunOperation" {
 				log.Infoln(dryRunMessage)
@@ -86,18 +286,191 @@ func main() {
 				log.Fatalln(err.Error())
 			}
 		}
-	// If -mount-point is specified, increase AWS EBS size directly.
-	case *mountPoint != "" && *pvc == "":
-		log.Infof("-mount-point=%s is specified. Increasing AWS EBS size directly...", *mountPoint)
-
-		volumeIDsList := GetEBSVolumeIDsByMountPoint(*mountPoint)
+	// If volume IDs or a volume filter are specified, increase AWS EBS size directly without the host discovery.
+	case *volumeIDs != "" || *volumeFilter != "":
+		var volumeIDsList []string
+		if *volumeIDs != "" {
+			log.Infof("-volume-id=%s is specified. Increasing AWS EBS size directly...", *volumeIDs)
+			volumeIDsList, err = ParseVolumeIDs(*volumeIDs)
+		} else {
+			log.Infof("-volume-filter=%s is specified. Increasing AWS EBS size directly...", *volumeFilter)
+			var filters []*ec2.Filter
+			if filters, err = ParseVolumeFilter(*volumeFilter); err == nil {
+				volumeIDsList, err = GetEBSVolumeIDsByFilter(filters)
+			}
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
 		if len(volumeIDsList) == 0 {
-			log.Fatalln("No volume IDs found. Try to run the program with -log-level=debug flag.")
+			log.Fatalln("No EBS volumes match the volume filter.")
+		}
+		log.Infof("EBS volumes to be enlarged: %v", volumeIDsList)
+
+		var volumesList []EBSVolume
+		for _, volumeID := range volumeIDsList {
+			volumesList = append(volumesList, EBSVolume{VolumeID: volumeID})
 		}
+		waitForModificationCooldown(volumesList)
 
 		for _, volumeID := range volumeIDsList {
-			err := EnlargeVolumeByID(&volumeID, percents, createSnapshot, dryRun, waitForModifying)
+			volumeID := volumeID
+			_, err := EnlargeVolumeByID(&volumeID, sizingPolicy, createSnapshot, dryRun, waitForModifying)
+			if errors.Is(err, errNoGrowth) {
+				continue
+			}
+			if awsError, ok := err.(awserr.Error); ok && awsError.Code() == "DryRunOperation" {
+				log.Infof("Request for the EBS volume %s would have succeeded, but -dry-run=true flag is set.", volumeID)
+				continue
+			}
 			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+	// If a mount point, a device or a filesystem is specified, increase AWS EBS size directly.
+	case targetsNumber == 1:
+		var stack *StorageStack
+		switch {
+		case *mountPoint != "":
+			log.Infof("-mount-point=%s is specified. Increasing AWS EBS size directly...", *mountPoint)
+			stack, err = BuildStorageStack(*mountPoint)
+		case *targetDevice != "":
+			log.Infof("-device=%s is specified. Increasing AWS EBS size directly...", *targetDevice)
+			stack, err = BuildDeviceStorageStack(*targetDevice)
+		case *fsUUID != "":
+			log.Infof("-fs-uuid=%s is specified. Increasing AWS EBS size directly...", *fsUUID)
+			var device string
+			if device, err = ResolveFilesystemUUID(*fsUUID); err == nil {
+				stack, err = BuildDeviceStorageStack(device)
+			}
+		case *fsLabel != "":
+			log.Infof("-fs-label=%s is specified. Increasing AWS EBS size directly...", *fsLabel)
+			var device string
+			if device, err = ResolveFilesystemLabel(*fsLabel); err == nil {
+				stack, err = BuildDeviceStorageStack(device)
+			}
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		volumesList, err := GetEBSVolumes(stack)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// The increment is computed from the filesystem usage if a free space target is set.
+		if freeSpaceTarget.IsSet() {
+			increment, err := TargetFreeSpaceIncrement(stack, freeSpaceTarget)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if increment <= 0 {
+				log.Infof("\"%s\" already has the target free space, so nothing is enlarged.", stack)
+				os.Exit(0)
+			}
+			log.Infof("The devices of \"%s\" have to grow by %d GB each to reach the target free space.", stack, increment)
+			sizingPolicy.Add = increment
+		}
+
+		// Volumes under LVM logical volumes grow by the amount the logical volume needs.
+		lvmPlan, err := PlanLVMGrowth(stack, volumesList, sizingPolicy)
+		if errors.Is(err, errNoGrowth) {
+			os.Exit(0)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// Extending the logical volume on the right physical volumes requires
+		// the plan.
+		if *extendLVM && lvmPlan == nil && stack.Devices[0].IsLogicalVolume() {
+			log.Fatalf("-extend-lvm is set, but the logical volume \"%s\" of \"%s\" can't be extended.", stack.Devices[0].MapperName, stack)
+		}
+
+		// Members of md arrays grow to the same size.
+		mdPlan, err := PlanMDGrowth(stack, volumesList, sizingPolicy)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// Refuse to enlarge volumes if their filesystem can't be grown
+		// afterwards. Growing the filesystem requires growing every layer
+		// under it, so only layers with a growth plan are allowed.
+		if *resizeFS {
+			if stack.Mount == nil {
+				log.Fatalf("-resize-filesystem is set, but \"%s\" isn't mounted. Only mounted filesystems can be grown.", stack)
+			}
+			if err := CheckFilesystemResizable(stack.Mount); err != nil {
+				log.Fatalln(err)
+			}
+			for _, device := range stack.DevicesOfKind(DeviceKindDMLinear, DeviceKindMD, DeviceKindDM, DeviceKindLoop) {
+				if (device.Kind == DeviceKindDMLinear && lvmPlan != nil) || (device.Kind == DeviceKindMD && mdPlan != nil) {
+					continue
+				}
+				log.Fatalf("-resize-filesystem is set, but the %s device \"%s\" of \"%s\" can't be grown.", device.Kind, device.Name, stack)
+			}
+		}
+
+		// resize-filesystem implies growing the layers present in the stack.
+		// The layers above partitions can only grow if the partitions do.
+		growPartitions := *growPartition || *resizeFS || *resizeCryptLayer || *growMD || *extendLVM
+		growArray := *growMD || (*resizeFS && mdPlan != nil)
+		extendLogicalVolume := *extendLVM || (*resizeFS && lvmPlan != nil)
+
+		// dm-crypt mappings keep their size until they're resized.
+		cryptLayers := stack.DevicesOfKind(DeviceKindDMCrypt)
+		resizeCrypt := func() {
+			if *resizeCryptLayer || *resizeFS {
+				if err := ResizeCryptLayers(cryptLayers); err != nil {
+					log.Fatalln(err)
+				}
+			}
+		}
+
+		var volumesToEnlarge []EBSVolume
+		for _, volume := range volumesList {
+			if lvmPlan != nil {
+				if _, ok := lvmPlan.VolumeGrowth[volume.VolumeID]; !ok {
+					continue
+				}
+			}
+			volumesToEnlarge = append(volumesToEnlarge, volume)
+		}
+
+		// The partition tables are only read here, so no volume is enlarged
+		// if any of its partitions can't be extended afterwards.
+		if growPartitions {
+			for _, volume := range volumesToEnlarge {
+				for _, partition := range volume.Partitions {
+					if err := CheckPartitionGrowable(filepath.Join(*hostDevPath, volume.Device), partition.Number); err != nil {
+						log.Fatalln(err)
+					}
+				}
+			}
+		}
+
+		waitForModificationCooldown(volumesToEnlarge)
+
+		newSizes := make(map[string]int64)
+		for _, volume := range volumesList {
+			var newSize int64
+			switch {
+			case lvmPlan != nil:
+				growth, ok := lvmPlan.VolumeGrowth[volume.VolumeID]
+				if !ok {
+					log.Infof("EBS volume %s doesn't have to grow to extend the logical volume.", volume.VolumeID)
+					continue
+				}
+				newSize, err = EnlargeVolumeByIDBy(&volume.VolumeID, growth, createSnapshot, dryRun, waitForModifying)
+			case mdPlan != nil:
+				newSize, err = EnlargeVolumeByIDTo(&volume.VolumeID, mdPlan.VolumeSizes[volume.VolumeID], createSnapshot, dryRun, waitForModifying)
+			default:
+				newSize, err = EnlargeVolumeByID(&volume.VolumeID, sizingPolicy, createSnapshot, dryRun, waitForModifying)
+			}
+			// Volumes, which don't have to grow, keep their size, and the
+			// layers above them are still grown.
+			if err != nil && !errors.Is(err, errNoGrowth) {
 				if awsError, ok := err.(awserr.Error); ok {
 					switch awsError.Code() {
 					case "DryRunOperation":
@@ -107,16 +480,75 @@ func main() {
 						log.Fatalln(awsError.Error())
 					}
 				} else {
-					log.Fatalln(err.Error())
+					log.Fatalln(err)
 				}
 			}
+			newSizes[volume.VolumeID] = newSize
+		}
+
+		// Partitions and filesystems can only be grown when the kernel knows
+		// the new size of the disks.
+		if *waitForDevice || *growPartition || *resizeCryptLayer || *growMD || *extendLVM || *resizeFS {
+			for _, volume := range volumesList {
+				if _, ok := newSizes[volume.VolumeID]; !ok {
+					continue
+				}
+				log.Infof("Waiting for the kernel to report the new size of \"%s\"...", volume.Device)
+				err := WaitForBlockDeviceSize(volume.Device, newSizes[volume.VolumeID]*GiB, *deviceTimeout)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+		}
+
+		if growPartitions {
+			for _, volume := range volumesList {
+				if _, ok := newSizes[volume.VolumeID]; !ok {
+					continue
+				}
+				for _, partition := range volume.Partitions {
+					log.Infof("Extending partition \"%s\" to the end of the disk...", partition.Name)
+					err := GrowPartition(filepath.Join(*hostDevPath, volume.Device), partition.Number)
+					if err != nil {
+						log.Fatalln(err)
+					}
+				}
+			}
+		}
+
+		// dm-crypt mappings may be located at any level of the stack, and
+		// resizing them to the size of the underlying devices is idempotent,
+		// so they're resized after every growing layer.
+		resizeCrypt()
+
+		if growArray {
+			if mdPlan == nil {
+				log.Warnf("-grow-md is set, but \"%s\" isn't located on an md array.", stack)
+			} else if err := mdPlan.Apply(); err != nil {
+				log.Fatalln(err)
+			} else {
+				resizeCrypt()
+			}
+		}
+
+		if extendLogicalVolume {
+			if lvmPlan == nil {
+				log.Warnf("-extend-lvm is set, but no LVM layout was found for \"%s\".", stack)
+			} else if err := lvmPlan.Apply(); err != nil {
+				log.Fatalln(err)
+			} else {
+				resizeCrypt()
+			}
+		}
+
+		if *resizeFS {
+			if err := ResizeFilesystem(stack.Mount); err != nil {
+				log.Fatalln(err)
+			}
 		}
-	case *mountPoint != "" && *pvc != "":
-		flag.Usage()
-		log.Fatalln("mount-point and pvc cannot be defined together.")
 	default:
 		flag.Usage()
-		log.Fatalln("Either mount-point or pvc has to be defined.")
+		log.Fatalln("Either mount-point, device, fs-uuid, fs-label, volume-id, volume-filter or pvc has to be defined.")
 	}
 
 	os.Exit(0)
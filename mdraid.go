@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// mdGrowableLevels lists RAID levels, whose arrays can use grown members
+// with "mdadm --grow --size=max". RAID0 and linear arrays don't support
+// changing the component size.
+var mdGrowableLevels = map[string]bool{
+	"raid1":  true,
+	"raid4":  true,
+	"raid5":  true,
+	"raid6":  true,
+	"raid10": true,
+}
+
+// MDGrowthPlan describes how to grow a software RAID array and the EBS
+// volumes of its members.
+type MDGrowthPlan struct {
+	// Array is the path of the array device, e.g. /dev/md0.
+	Array string
+	Level string
+	// Members lists the member devices of the array, e.g. nvme1n1p1.
+	Members []string
+	// VolumeSizes maps IDs of EBS volumes to their new size in GB. All
+	// members grow to the same size.
+	VolumeSizes map[string]int64
+}
+
+// PlanMDGrowth returns the growth plan if the storage stack is built on an
+// md array, otherwise nil. The EBS volumes of all members grow to the same
+// size, which is the size of the largest one increased as the sizing policy
+// specifies. Arrays of levels which can't be grown are refused.
+func PlanMDGrowth(stack *StorageStack, volumes []EBSVolume, sizing *SizingPolicy) (*MDGrowthPlan, error) {
+	if len(stack.Devices) != 1 || stack.Devices[0].Kind != DeviceKindMD {
+		return nil, nil
+	}
+	array := stack.Devices[0]
+
+	var members []string
+	for _, member := range array.Children {
+		members = append(members, member.Name)
+	}
+	sort.Strings(members)
+	log.Infof("\"%s\" is located on the %s array \"%s\" with members %v.", stack, array.RAIDLevel, array.Name, members)
+
+	if !mdGrowableLevels[array.RAIDLevel] {
+		return nil, fmt.Errorf("Growing %s array \"%s\" isn't supported", array.RAIDLevel, array.Name)
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("No members of \"%s\" found", array.Name)
+	}
+
+	plan := &MDGrowthPlan{
+		Array:       array.Path(),
+		Level:       array.RAIDLevel,
+		Members:     members,
+		VolumeSizes: make(map[string]int64),
+	}
+
+	var largestSize int64
+	var membersVolumes []string
+	for _, member := range array.Children {
+		disks := member.Disks()
+		if len(disks) != 1 {
+			return nil, fmt.Errorf("Member \"%s\" of \"%s\" spans several disks", member.Name, array.Name)
+		}
+
+		var volumeID string
+		for _, volume := range volumes {
+			if volume.Device == disks[0].Name {
+				volumeID = volume.VolumeID
+			}
+		}
+		if volumeID == "" {
+			return nil, fmt.Errorf("Member \"%s\" of \"%s\" isn't located on an EBS volume", member.Name, array.Name)
+		}
+		membersVolumes = append(membersVolumes, volumeID)
+
+		if disks[0].Size > largestSize {
+			largestSize = disks[0].Size
+		}
+	}
+
+	largestSizeInGB := (largestSize + GiB - 1) / GiB
+	newSize := sizing.NewSize(largestSizeInGB)
+	for _, volumeID := range membersVolumes {
+		plan.VolumeSizes[volumeID] = newSize
+	}
+	log.Infof("EBS volumes of all members of \"%s\" grow to %d GB.", array.Name, newSize)
+
+	return plan, nil
+}
+
+// Apply grows the array to the size of its grown members with
+// "mdadm --grow --size=max".
+func (plan *MDGrowthPlan) Apply() error {
+	log.Infof("Growing %s array \"%s\"...", plan.Level, plan.Array)
+	if _, err := commandExecutor.Execute("mdadm", "--grow", plan.Array, "--size=max"); err != nil {
+		return err
+	}
+
+	log.Infof("Array \"%s\" was grown.", plan.Array)
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountInfo describes a single line of the /proc/<pid>/mountinfo file.
+// See proc(5) for the description of the fields.
+type MountInfo struct {
+	MountID        int
+	ParentID       int
+	Major          uint32
+	Minor          uint32
+	Root           string
+	MountPoint     string
+	MountOptions   string
+	OptionalFields []string
+	FSType         string
+	Source         string
+	SuperOptions   string
+}
+
+// ReadMountInfo reads and parses the mountinfo file located at the path.
+func ReadMountInfo(path string) ([]MountInfo, error) {
+	mountInfoFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer mountInfoFile.Close()
+
+	return ParseMountInfo(mountInfoFile)
+}
+
+// ParseMountInfo parses the contents of a mountinfo file. Mounts are returned
+// in the order they appear in the file, so later entries are mounted on top
+// of earlier ones.
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		mount, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse mountinfo line %d: %s", lineNumber, err)
+		}
+		mounts = append(mounts, mount)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// parseMountInfoLine parses a line of the following format:
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (MountInfo, error) {
+	var mount MountInfo
+
+	fields := strings.Fields(line)
+
+	// The optional fields are terminated by a single hyphen.
+	separatorIndex := -1
+	for index := 6; index < len(fields); index++ {
+		if fields[index] == "-" {
+			separatorIndex = index
+			break
+		}
+	}
+	if len(fields) < 7 || separatorIndex == -1 || len(fields) < separatorIndex+3 {
+		return mount, fmt.Errorf("wrong number of fields in \"%s\"", line)
+	}
+
+	var err error
+	if mount.MountID, err = strconv.Atoi(fields[0]); err != nil {
+		return mount, fmt.Errorf("wrong mount ID \"%s\"", fields[0])
+	}
+	if mount.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+		return mount, fmt.Errorf("wrong parent ID \"%s\"", fields[1])
+	}
+
+	majorMinor := strings.Split(fields[2], ":")
+	if len(majorMinor) != 2 {
+		return mount, fmt.Errorf("wrong major:minor \"%s\"", fields[2])
+	}
+	major, err := strconv.ParseUint(majorMinor[0], 10, 32)
+	if err != nil {
+		return mount, fmt.Errorf("wrong major number \"%s\"", majorMinor[0])
+	}
+	minor, err := strconv.ParseUint(majorMinor[1], 10, 32)
+	if err != nil {
+		return mount, fmt.Errorf("wrong minor number \"%s\"", majorMinor[1])
+	}
+	mount.Major = uint32(major)
+	mount.Minor = uint32(minor)
+
+	mount.Root = unescapeMountInfoField(fields[3])
+	mount.MountPoint = unescapeMountInfoField(fields[4])
+	mount.MountOptions = fields[5]
+	mount.OptionalFields = fields[6:separatorIndex]
+	mount.FSType = unescapeMountInfoField(fields[separatorIndex+1])
+	mount.Source = unescapeMountInfoField(fields[separatorIndex+2])
+	if len(fields) > separatorIndex+3 {
+		mount.SuperOptions = fields[separatorIndex+3]
+	}
+
+	return mount, nil
+}
+
+// unescapeMountInfoField replaces octal escapes such as \040 (space),
+// \011 (tab), \012 (newline) and \134 (backslash) with the original characters.
+func unescapeMountInfoField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var builder strings.Builder
+	for index := 0; index < len(field); index++ {
+		if field[index] == '\\' && index+4 <= len(field) && isOctalDigits(field[index+1:index+4]) {
+			value, _ := strconv.ParseUint(field[index+1:index+4], 8, 8)
+			builder.WriteByte(byte(value))
+			index += 3
+			continue
+		}
+		builder.WriteByte(field[index])
+	}
+
+	return builder.String()
+}
+
+func isOctalDigits(value string) bool {
+	for _, character := range value {
+		if character < '0' || character > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// FindMountByPath returns the mount containing the given path. The path may
+// be the mount point itself or any file or directory inside the filesystem,
+// so the mount with the longest matching mount point is chosen. If several
+// filesystems are stacked on the same path, the last one wins because it
+// hides the others.
+func FindMountByPath(mounts []MountInfo, path string) (*MountInfo, error) {
+	path = filepath.Clean(path)
+
+	var found *MountInfo
+	for index := range mounts {
+		if !isPathInside(path, mounts[index].MountPoint) {
+			continue
+		}
+		if found == nil || len(mounts[index].MountPoint) >= len(found.MountPoint) {
+			found = &mounts[index]
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("No mount found for the path \"%s\"", path)
+	}
+
+	return found, nil
+}
+
+// DeviceMounts returns one mount of every device in the order of the first
+// mounts of the devices. Bind mounts share the device, so the mount of the
+// filesystem root is preferred.
+func DeviceMounts(mounts []MountInfo) []*MountInfo {
+	var deviceMounts []*MountInfo
+	mountsByDevice := make(map[[2]uint32]int)
+	for index := range mounts {
+		mount := &mounts[index]
+		deviceNumber := [2]uint32{mount.Major, mount.Minor}
+		if seen, ok := mountsByDevice[deviceNumber]; ok {
+			if deviceMounts[seen].Root != "/" && mount.Root == "/" {
+				deviceMounts[seen] = mount
+			}
+			continue
+		}
+		mountsByDevice[deviceNumber] = len(deviceMounts)
+		deviceMounts = append(deviceMounts, mount)
+	}
+	return deviceMounts
+}
+
+// isPathInside reports whether the path is the mount point itself or is
+// located under it. Paths are compared by components, so /data10 isn't
+// considered to be inside /data1.
+func isPathInside(path, mountPoint string) bool {
+	if path == mountPoint || mountPoint == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, mountPoint+"/")
+}
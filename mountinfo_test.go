@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFindMountByPath(t *testing.T) {
+	mounts, err := ParseMountInfo(strings.NewReader(`22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+35 22 259:3 / /data1 rw,relatime shared:2 - xfs /dev/nvme1n1 rw
+36 22 259:4 / /data10 rw,relatime shared:3 - xfs /dev/nvme2n1 rw
+37 22 0:45 / /mnt/with\040space rw,relatime shared:4 - tmpfs tmpfs rw
+38 35 259:5 / /data1 rw,relatime shared:5 master:1 propagate_from:1 - ext4 /dev/nvme3n1 rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"/":                        "/",
+		"/etc/hosts":               "/",
+		"/data1":                   "/data1",
+		"/data1/":                  "/data1",
+		"/data1/pgdata/base":       "/data1",
+		"/data10/file":             "/data10",
+		"/data100":                 "/",
+		"/mnt/with space/file.txt": "/mnt/with space",
+	}
+	for path, expected := range tests {
+		mount, err := FindMountByPath(mounts, path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		if mount.MountPoint != expected {
+			t.Errorf("%s: expected the mount \"%s\", got \"%s\"", path, expected, mount.MountPoint)
+		}
+	}
+
+	// The filesystem mounted later on the same path hides the earlier one.
+	mount, err := FindMountByPath(mounts, "/data1/pgdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mount.MountID != 38 || mount.Source != "/dev/nvme3n1" {
+		t.Errorf("Expected the stacked mount 38 of /dev/nvme3n1, got %d of %s", mount.MountID, mount.Source)
+	}
+	if expected := []string{"shared:5", "master:1", "propagate_from:1"}; strings.Join(mount.OptionalFields, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected the optional fields %v, got %v", expected, mount.OptionalFields)
+	}
+
+	// Without the root filesystem no mount contains the path.
+	if mount, err := FindMountByPath(mounts[1:], "/etc/hosts"); err == nil || !strings.Contains(err.Error(), "No mount found") {
+		t.Errorf("Expected the error about the missing mount, got %v and %v", mount, err)
+	}
+}
+
+func TestParseMountInfoErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing separator":  "22 1 259:1 / / rw,relatime shared:1 ext4 /dev/nvme0n1p1 rw",
+		"missing source":     "22 1 259:1 / / rw,relatime shared:1 - ext4",
+		"wrong major:minor":  "22 1 259 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw",
+		"wrong minor number": "22 1 259:x / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw",
+		"wrong mount ID":     "x 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw",
+	}
+
+	for name, line := range tests {
+		mounts, err := ParseMountInfo(strings.NewReader("22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw\n" + line + "\n"))
+		if err == nil {
+			t.Errorf("%s: expected an error, got %v", name, mounts)
+			continue
+		}
+		if !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%s: expected the error about line 2, got \"%s\"", name, err)
+		}
+	}
+}
+
+// writeMountInfo writes a fake <proc-path>/self/mountinfo with the lines.
+func writeMountInfo(t *testing.T, lines ...string) {
+	procPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procPath, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(procPath, "self", "mountinfo"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalProcPath := *hostProcPath
+	*hostProcPath = procPath
+	t.Cleanup(func() { *hostProcPath = originalProcPath })
+}
+
+func TestFindMountOfPath(t *testing.T) {
+	directory, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(directory, &stat); err != nil {
+		t.Fatal(err)
+	}
+	majorMinor := fmt.Sprintf("%d:%d", deviceMajor(uint64(stat.Dev)), deviceMinor(uint64(stat.Dev)))
+	otherMajorMinor := fmt.Sprintf("%d:%d", deviceMajor(uint64(stat.Dev))+1, deviceMinor(uint64(stat.Dev)))
+
+	writeMountInfo(t,
+		"22 1 "+otherMajorMinor+" / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw",
+		"35 22 "+majorMinor+" / "+directory+" rw,relatime shared:2 - xfs /dev/nvme1n1 rw",
+	)
+	mount, err := findMountOfPath(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mount.MountPoint != directory {
+		t.Errorf("Expected the mount \"%s\", got \"%s\"", directory, mount.MountPoint)
+	}
+
+	for _, path := range []string{"relative/path", filepath.Join(directory, "typo")} {
+		if _, err := findMountOfPath(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+
+	// Without its own mount the directory would resolve to the root
+	// filesystem of another device.
+	writeMountInfo(t, "22 1 "+otherMajorMinor+" / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw")
+	if mount, err := findMountOfPath(directory); err == nil {
+		t.Errorf("Expected an error, got the mount \"%s\"", mount.MountPoint)
+	}
+}
+
+func TestDeviceMounts(t *testing.T) {
+	mounts, err := ParseMountInfo(strings.NewReader(`22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+40 22 259:3 /pods/a/volume /var/lib/kubelet/pods/a/volume rw,relatime shared:2 - xfs /dev/nvme1n1 rw
+41 22 259:3 / /data rw,relatime shared:3 - xfs /dev/nvme1n1 rw
+42 22 259:3 / /data-again rw,relatime shared:4 - xfs /dev/nvme1n1 rw
+43 22 259:4 /subdir /mnt/subdir rw,relatime shared:5 - xfs /dev/nvme2n1 rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mountPoints []string
+	for _, mount := range DeviceMounts(mounts) {
+		mountPoints = append(mountPoints, mount.MountPoint)
+	}
+	if expected := []string{"/", "/data", "/mnt/subdir"}; strings.Join(mountPoints, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected the mounts %v, got %v", expected, mountPoints)
+	}
+}
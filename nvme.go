@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// nvmeIoctlAdminCmd is _IOWR('N', 0x41, struct nvme_admin_cmd).
+	nvmeIoctlAdminCmd = 0xC0484E41
+	// nvmeAdminIdentify is the opcode of the NVMe admin identify command.
+	nvmeAdminIdentify = 0x06
+	// nvmeIdentifyCNSController requests the identify controller data structure.
+	nvmeIdentifyCNSController = 0x01
+	// nvmeIdentifyControllerSize is the size of the identify controller data structure.
+	nvmeIdentifyControllerSize = 4096
+
+	ebsNVMeModel              = "Amazon Elastic Block Store"
+	instanceStoreNVMeModel    = "Amazon EC2 NVMe Instance Storage"
+	nvmeVendorSpecificOffset  = 3072
+	ebsBlockDeviceNameLength  = 32
+	nvmeSerialNumberOffset    = 4
+	nvmeSerialNumberLength    = 20
+	nvmeModelNumberOffset     = 24
+	nvmeModelNumberLength     = 40
+	nvmeFirmwareVersionOffset = 64
+	nvmeFirmwareVersionLength = 8
+)
+
+// nvmeAdminCommand is struct nvme_admin_cmd from linux/nvme_ioctl.h. Address
+// is a 64-bit field, which matches the pointer size on supported platforms.
+type nvmeAdminCommand struct {
+	Opcode      uint8
+	Flags       uint8
+	Reserved1   uint16
+	NSID        uint32
+	CDW2        uint32
+	CDW3        uint32
+	Metadata    uint64
+	Address     unsafe.Pointer
+	MetadataLen uint32
+	DataLen     uint32
+	CDW10       uint32
+	CDW11       uint32
+	CDW12       uint32
+	CDW13       uint32
+	CDW14       uint32
+	CDW15       uint32
+	TimeoutMs   uint32
+	Result      uint32
+}
+
+// NVMeIdentifyController contains the fields of the NVMe identify controller
+// data structure used to recognize EBS volumes.
+type NVMeIdentifyController struct {
+	VendorID        uint16
+	SerialNumber    string
+	ModelNumber     string
+	FirmwareVersion string
+	// BlockDeviceName is the device name requested in the block device
+	// mapping, e.g. "sdf" or "/dev/xvdf". Only EBS volumes report it in the
+	// vendor specific area.
+	BlockDeviceName string
+}
+
+// ReadNVMeIdentifyController sends the identify controller admin command to
+// the NVMe device and parses the response.
+func ReadNVMeIdentifyController(devicePath string) (*NVMeIdentifyController, error) {
+	device, err := os.OpenFile(devicePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer device.Close()
+
+	data := make([]byte, nvmeIdentifyControllerSize)
+	command := nvmeAdminCommand{
+		Opcode:  nvmeAdminIdentify,
+		Address: unsafe.Pointer(&data[0]),
+		DataLen: nvmeIdentifyControllerSize,
+		CDW10:   nvmeIdentifyCNSController,
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), nvmeIoctlAdminCmd, uintptr(unsafe.Pointer(&command)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return nil, fmt.Errorf("NVMe identify controller command for \"%s\" failed: %s", devicePath, errno)
+	}
+
+	return ParseNVMeIdentifyController(data)
+}
+
+// ParseNVMeIdentifyController parses the identify controller data structure
+// in the way the ebsnvme-id tool does.
+func ParseNVMeIdentifyController(data []byte) (*NVMeIdentifyController, error) {
+	if len(data) < nvmeVendorSpecificOffset+ebsBlockDeviceNameLength {
+		return nil, fmt.Errorf("Identify controller data is too short: %d bytes", len(data))
+	}
+
+	identify := &NVMeIdentifyController{
+		VendorID:        uint16(data[0]) | uint16(data[1])<<8,
+		SerialNumber:    nvmeString(data[nvmeSerialNumberOffset : nvmeSerialNumberOffset+nvmeSerialNumberLength]),
+		ModelNumber:     nvmeString(data[nvmeModelNumberOffset : nvmeModelNumberOffset+nvmeModelNumberLength]),
+		FirmwareVersion: nvmeString(data[nvmeFirmwareVersionOffset : nvmeFirmwareVersionOffset+nvmeFirmwareVersionLength]),
+	}
+
+	if identify.ModelNumber == ebsNVMeModel {
+		identify.BlockDeviceName = nvmeString(data[nvmeVendorSpecificOffset : nvmeVendorSpecificOffset+ebsBlockDeviceNameLength])
+	}
+
+	return identify, nil
+}
+
+// nvmeString converts a space or NUL padded ASCII field to a string.
+func nvmeString(field []byte) string {
+	if index := bytes.IndexByte(field, 0); index != -1 {
+		field = field[:index]
+	}
+	return strings.TrimSpace(string(field))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNVMeIdentifyController(t *testing.T) {
+	// Identify controller data structures of an EBS volume attached as sdf,
+	// of one attached as /dev/xvdba and of an instance store volume, which
+	// has no device name in the vendor specific area.
+	tests := map[string]NVMeIdentifyController{
+		"ebs-sdf.bin": {
+			VendorID:        0x1d0f,
+			SerialNumber:    "vol0123456789abcdef0",
+			ModelNumber:     ebsNVMeModel,
+			FirmwareVersion: "1.0",
+			BlockDeviceName: "sdf",
+		},
+		"ebs-dev-xvdba.bin": {
+			VendorID:        0x1d0f,
+			SerialNumber:    "vol0fedcba9876543210",
+			ModelNumber:     ebsNVMeModel,
+			FirmwareVersion: "2.0",
+			BlockDeviceName: "/dev/xvdba",
+		},
+		"instance-store.bin": {
+			VendorID:        0x1d0f,
+			SerialNumber:    "AWS22A2B3C4D5E6F7A8B",
+			ModelNumber:     instanceStoreNVMeModel,
+			FirmwareVersion: "0",
+		},
+	}
+
+	for name, expected := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "nvme", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identify, err := ParseNVMeIdentifyController(data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if *identify != expected {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, *identify)
+		}
+
+		if _, err := ParseNVMeIdentifyController(data[:nvmeVendorSpecificOffset]); err == nil {
+			t.Errorf("%s: expected an error for the truncated data", name)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// VolumePerformance is the provisioned performance of an EBS volume.
+// Throughput is in MiB/s. Zero values mean the volume type doesn't support
+// provisioning them.
+type VolumePerformance struct {
+	Iops       int64
+	Throughput int64
+}
+
+// volumeTypeLimits are the size range in GiB and the limits of the
+// provisioned performance of an EBS volume type. Zero performance limits mean
+// the value can't be provisioned.
+type volumeTypeLimits struct {
+	MinSize              int64
+	MaxSize              int64
+	MinIops              int64
+	MaxIops              int64
+	MaxIopsPerGiB        int64
+	MinThroughput        int64
+	MaxThroughput        int64
+	MaxThroughputPerIops float64
+}
+
+// ebsVolumeTypeLimits lists the limits of EBS volume types. io2 volumes are
+// Block Express ones. See https://docs.aws.amazon.com/ebs/latest/userguide/ebs-volume-types.html
+var ebsVolumeTypeLimits = map[string]volumeTypeLimits{
+	"gp2": {
+		MinSize: 1,
+		MaxSize: 16384,
+	},
+	"gp3": {
+		MinSize:              1,
+		MaxSize:              16384,
+		MinIops:              3000,
+		MaxIops:              16000,
+		MaxIopsPerGiB:        500,
+		MinThroughput:        125,
+		MaxThroughput:        1000,
+		MaxThroughputPerIops: 0.25,
+	},
+	"io1": {
+		MinSize:       4,
+		MaxSize:       16384,
+		MinIops:       100,
+		MaxIops:       64000,
+		MaxIopsPerGiB: 50,
+	},
+	"io2": {
+		MinSize:       4,
+		MaxSize:       65536,
+		MinIops:       100,
+		MaxIops:       256000,
+		MaxIopsPerGiB: 500,
+	},
+	"st1": {
+		MinSize: 125,
+		MaxSize: 16384,
+	},
+	"sc1": {
+		MinSize: 125,
+		MaxSize: 16384,
+	},
+	"standard": {
+		MinSize: 1,
+		MaxSize: 1024,
+	},
+}
+
+// convertibleVolumeTypes are the volume types volumes can be converted to.
+// Volumes can't be converted to the previous generation standard type.
+var convertibleVolumeTypes = []string{"gp2", "gp3", "io1", "io2", "st1", "sc1"}
+
+const (
+	// IopsPolicyKeep keeps the provisioned IOPS.
+	IopsPolicyKeep = "keep"
+	// IopsPolicyPerGiB keeps the IOPS per GiB ratio.
+	IopsPolicyPerGiB = "per-gib"
+	// ThroughputPolicyKeep keeps the provisioned throughput.
+	ThroughputPolicyKeep = "keep"
+	// ThroughputPolicyProportional scales the throughput with the size.
+	ThroughputPolicyProportional = "proportional"
+)
+
+// PerformancePolicy tells how to change the provisioned IOPS and throughput
+// of volumes together with their size. Zero minimums and maximums aren't
+// applied.
+type PerformancePolicy struct {
+	IopsPolicy       string
+	MinIops          int64
+	MaxIops          int64
+	ThroughputPolicy string
+	MinThroughput    int64
+	MaxThroughput    int64
+}
+
+// newPerformancePolicy creates the performance policy from the flags.
+func newPerformancePolicy() *PerformancePolicy {
+	return &PerformancePolicy{
+		IopsPolicy:       *iopsPolicy,
+		MinIops:          *minIops,
+		MaxIops:          *maxIops,
+		ThroughputPolicy: *throughputPolicy,
+		MinThroughput:    *minThroughput,
+		MaxThroughput:    *maxThroughput,
+	}
+}
+
+// Validate returns an error if the policy is inconsistent.
+func (policy *PerformancePolicy) Validate() error {
+	if policy.IopsPolicy != IopsPolicyKeep && policy.IopsPolicy != IopsPolicyPerGiB {
+		return fmt.Errorf("Wrong IOPS policy \"%s\"", policy.IopsPolicy)
+	}
+	if policy.ThroughputPolicy != ThroughputPolicyKeep && policy.ThroughputPolicy != ThroughputPolicyProportional {
+		return fmt.Errorf("Wrong throughput policy \"%s\"", policy.ThroughputPolicy)
+	}
+	if policy.MinIops < 0 || policy.MaxIops < 0 || (policy.MaxIops > 0 && policy.MinIops > policy.MaxIops) {
+		return fmt.Errorf("Wrong IOPS range %d-%d", policy.MinIops, policy.MaxIops)
+	}
+	if policy.MinThroughput < 0 || policy.MaxThroughput < 0 || (policy.MaxThroughput > 0 && policy.MinThroughput > policy.MaxThroughput) {
+		return fmt.Errorf("Wrong throughput range %d-%d", policy.MinThroughput, policy.MaxThroughput)
+	}
+	return nil
+}
+
+// Plan returns the performance of the volume of the type after growing
+// from currentSize to newSize GiB. The result is kept within the limits of
+// the volume type. Volume types without provisioned performance keep it.
+func (policy *PerformancePolicy) Plan(volumeType string, currentSize, newSize int64, current VolumePerformance) VolumePerformance {
+	limits, ok := ebsVolumeTypeLimits[volumeType]
+	if !ok {
+		return current
+	}
+
+	planned := current
+	if limits.MaxIops > 0 {
+		if policy.IopsPolicy == IopsPolicyPerGiB && currentSize > 0 {
+			planned.Iops = scaleBySize(current.Iops, currentSize, newSize)
+		}
+		planned.Iops = clamp(planned.Iops, policy.MinIops, policy.MaxIops)
+
+		// Small volumes may always have the minimum IOPS, even above the
+		// IOPS per GiB ratio.
+		maxIops := limits.MaxIops
+		if limits.MaxIopsPerGiB*newSize < maxIops {
+			maxIops = limits.MaxIopsPerGiB * newSize
+		}
+		if maxIops < limits.MinIops {
+			maxIops = limits.MinIops
+		}
+		planned.Iops = clampToLimit(planned.Iops, limits.MinIops, maxIops, volumeType, "IOPS")
+	}
+
+	if limits.MaxThroughput > 0 {
+		if policy.ThroughputPolicy == ThroughputPolicyProportional && currentSize > 0 {
+			planned.Throughput = scaleBySize(current.Throughput, currentSize, newSize)
+		}
+		planned.Throughput = clamp(planned.Throughput, policy.MinThroughput, policy.MaxThroughput)
+
+		maxThroughput := limits.MaxThroughput
+		if limit := int64(math.Floor(float64(planned.Iops) * limits.MaxThroughputPerIops)); limits.MaxThroughputPerIops > 0 && limit < maxThroughput {
+			maxThroughput = limit
+		}
+		if maxThroughput < limits.MinThroughput {
+			maxThroughput = limits.MinThroughput
+		}
+		planned.Throughput = clampToLimit(planned.Throughput, limits.MinThroughput, maxThroughput, volumeType, "throughput")
+	}
+
+	return planned
+}
+
+// VolumeProfile is the type, size in GiB and performance of an EBS volume.
+type VolumeProfile struct {
+	VolumeType string
+	Size       int64
+	VolumePerformance
+}
+
+func (profile VolumeProfile) String() string {
+	description := fmt.Sprintf("%s %d GiB", profile.VolumeType, profile.Size)
+	if profile.Iops > 0 {
+		description += fmt.Sprintf(", %d IOPS", profile.Iops)
+	}
+	if profile.Throughput > 0 {
+		description += fmt.Sprintf(", %d MiB/s", profile.Throughput)
+	}
+	return description
+}
+
+// PlanVolumeProfile returns the profile of the volume after growing to
+// newSize GiB and converting to volumeType. An empty volumeType keeps the
+// type. The error is returned if newSize is out of the size range of the type.
+func (policy *PerformancePolicy) PlanVolumeProfile(current VolumeProfile, newSize int64, volumeType string) (VolumeProfile, error) {
+	if volumeType == "" {
+		volumeType = current.VolumeType
+	}
+
+	limits, ok := ebsVolumeTypeLimits[volumeType]
+	if ok && (newSize < limits.MinSize || newSize > limits.MaxSize) {
+		return VolumeProfile{}, fmt.Errorf("New size %d GiB is out of the %s size range %d-%d GiB", newSize, volumeType, limits.MinSize, limits.MaxSize)
+	}
+
+	planned := VolumeProfile{
+		VolumeType:        volumeType,
+		Size:              newSize,
+		VolumePerformance: current.VolumePerformance,
+	}
+	if volumeType == "gp2" {
+		planned.VolumePerformance = gp2Performance(newSize)
+	} else if limits.MaxIops > 0 || limits.MaxThroughput > 0 {
+		if volumeType != current.VolumeType {
+			planned.VolumePerformance = equivalentPerformance(current, limits)
+		}
+		planned.VolumePerformance = policy.Plan(volumeType, current.Size, newSize, planned.VolumePerformance)
+	} else if volumeType != current.VolumeType {
+		planned.VolumePerformance = VolumePerformance{}
+	}
+
+	return planned, nil
+}
+
+// equivalentPerformance returns the performance a volume of the type with
+// the limits needs to match the current volume. A gp2 volume is matched at
+// its burst level. Values the current volume doesn't have are set to the
+// minimums of the type.
+func equivalentPerformance(current VolumeProfile, limits volumeTypeLimits) VolumePerformance {
+	performance := current.VolumePerformance
+	if current.VolumeType == "gp2" {
+		performance.Iops = gp2BurstIops
+		if baseline := gp2Performance(current.Size); baseline.Iops > performance.Iops {
+			performance.Iops = baseline.Iops
+		}
+		performance.Throughput = gp2Throughput(current.Size)
+	}
+
+	if limits.MaxIops == 0 {
+		performance.Iops = 0
+	} else if performance.Iops == 0 {
+		performance.Iops = limits.MinIops
+	}
+	if limits.MaxThroughput == 0 {
+		performance.Throughput = 0
+	} else if performance.Throughput == 0 {
+		performance.Throughput = limits.MinThroughput
+	}
+
+	return performance
+}
+
+// gp2BurstIops is the IOPS gp2 volumes smaller than 1000 GiB burst to.
+const gp2BurstIops = 3000
+
+// gp2Performance returns the baseline IOPS of a gp2 volume of the size in
+// GiB, 3 IOPS per GiB within 100-16000.
+func gp2Performance(size int64) VolumePerformance {
+	return VolumePerformance{Iops: clamp(3*size, 100, 16000)}
+}
+
+// gp2Throughput returns the maximum throughput of a gp2 volume of the size
+// in GiB in MiB/s.
+func gp2Throughput(size int64) int64 {
+	if size <= 170 {
+		return 128
+	}
+	return 250
+}
+
+// scaleBySize scales the value proportionally to the size, rounding up.
+func scaleBySize(value, currentSize, newSize int64) int64 {
+	return int64(math.Ceil(float64(value) * float64(newSize) / float64(currentSize)))
+}
+
+// clamp keeps the value within the range. Zero bounds aren't applied.
+func clamp(value, minimum, maximum int64) int64 {
+	if minimum > 0 && value < minimum {
+		value = minimum
+	}
+	if maximum > 0 && value > maximum {
+		value = maximum
+	}
+	return value
+}
+
+// clampToLimit keeps the value within the limits of the volume type and
+// logs when it has to be changed.
+func clampToLimit(value, minimum, maximum int64, volumeType, name string) int64 {
+	limited := clamp(value, minimum, maximum)
+	if limited != value {
+		log.Infof("%d %s is out of the %s limits %d-%d, so %d is used.", value, name, volumeType, minimum, maximum, limited)
+	}
+	return limited
+}
+
+// isConvertibleVolumeType returns true if volumes can be converted to the type.
+func isConvertibleVolumeType(volumeType string) bool {
+	for _, convertibleType := range convertibleVolumeTypes {
+		if volumeType == convertibleType {
+			return true
+		}
+	}
+	return false
+}
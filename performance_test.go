@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPerformancePolicyPlan(t *testing.T) {
+	keep := &PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep}
+	scale := &PerformancePolicy{IopsPolicy: IopsPolicyPerGiB, ThroughputPolicy: ThroughputPolicyProportional}
+
+	tests := []struct {
+		name        string
+		policy      *PerformancePolicy
+		volumeType  string
+		currentSize int64
+		newSize     int64
+		current     VolumePerformance
+		expected    VolumePerformance
+	}{
+		{"gp3 keep", keep, "gp3", 100, 120, VolumePerformance{3000, 125}, VolumePerformance{3000, 125}},
+		{"gp3 keep under 6 GiB", keep, "gp3", 2, 3, VolumePerformance{3000, 125}, VolumePerformance{3000, 125}},
+		{"gp3 per-gib under 6 GiB", scale, "gp3", 4, 5, VolumePerformance{3000, 125}, VolumePerformance{3000, 157}},
+		{"gp3 per-gib", scale, "gp3", 100, 120, VolumePerformance{3000, 125}, VolumePerformance{3600, 150}},
+		{"gp3 maximum", scale, "gp3", 1000, 2000, VolumePerformance{12000, 800}, VolumePerformance{16000, 1000}},
+		{"gp3 throughput per IOPS", &PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep, MinThroughput: 900}, "gp3", 100, 120, VolumePerformance{3000, 125}, VolumePerformance{3000, 750}},
+		{"gp3 floor and ceiling", &PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep, MinIops: 6000, MaxThroughput: 200}, "gp3", 100, 120, VolumePerformance{3000, 500}, VolumePerformance{6000, 200}},
+		{"io1 ratio", scale, "io1", 10, 12, VolumePerformance{500, 0}, VolumePerformance{600, 0}},
+		{"io1 ratio limit", &PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep, MinIops: 5000}, "io1", 10, 12, VolumePerformance{500, 0}, VolumePerformance{600, 0}},
+		{"io2 ratio limit", scale, "io2", 10, 12, VolumePerformance{5000, 0}, VolumePerformance{6000, 0}},
+		{"gp2", scale, "gp2", 100, 120, VolumePerformance{300, 0}, VolumePerformance{300, 0}},
+	}
+
+	for _, test := range tests {
+		planned := test.policy.Plan(test.volumeType, test.currentSize, test.newSize, test.current)
+		if planned != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, planned)
+		}
+	}
+}
+
+func TestPlanVolumeProfile(t *testing.T) {
+	keep := &PerformancePolicy{IopsPolicy: IopsPolicyKeep, ThroughputPolicy: ThroughputPolicyKeep}
+
+	tests := []struct {
+		name       string
+		current    VolumeProfile
+		newSize    int64
+		volumeType string
+		expected   VolumeProfile
+		fails      bool
+	}{
+		{"gp2 size", VolumeProfile{"gp2", 100, VolumePerformance{300, 0}}, 120, "", VolumeProfile{"gp2", 120, VolumePerformance{360, 0}}, false},
+		{"small gp2 to gp3", VolumeProfile{"gp2", 1, VolumePerformance{100, 0}}, 2, "gp3", VolumeProfile{"gp3", 2, VolumePerformance{3000, 128}}, false},
+		{"gp2 to gp3 at burst level", VolumeProfile{"gp2", 100, VolumePerformance{300, 0}}, 120, "gp3", VolumeProfile{"gp3", 120, VolumePerformance{3000, 128}}, false},
+		{"large gp2 to gp3", VolumeProfile{"gp2", 2000, VolumePerformance{6000, 0}}, 2400, "gp3", VolumeProfile{"gp3", 2400, VolumePerformance{6000, 250}}, false},
+		{"io2 to gp3", VolumeProfile{"io2", 100, VolumePerformance{20000, 0}}, 120, "gp3", VolumeProfile{"gp3", 120, VolumePerformance{16000, 125}}, false},
+		{"gp3 to io1", VolumeProfile{"gp3", 100, VolumePerformance{3000, 125}}, 120, "io1", VolumeProfile{"io1", 120, VolumePerformance{3000, 0}}, false},
+		{"st1 to gp3", VolumeProfile{"st1", 500, VolumePerformance{}}, 600, "gp3", VolumeProfile{"gp3", 600, VolumePerformance{3000, 125}}, false},
+		{"gp3 to sc1", VolumeProfile{"gp3", 200, VolumePerformance{3000, 125}}, 240, "sc1", VolumeProfile{"sc1", 240, VolumePerformance{}}, false},
+		{"too small st1", VolumeProfile{"gp2", 100, VolumePerformance{300, 0}}, 120, "st1", VolumeProfile{}, true},
+		{"too large io2", VolumeProfile{"io2", 60000, VolumePerformance{10000, 0}}, 72000, "", VolumeProfile{}, true},
+	}
+
+	for _, test := range tests {
+		planned, err := keep.PlanVolumeProfile(test.current, test.newSize, test.volumeType)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", test.name, planned)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if planned != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, planned)
+		}
+	}
+}
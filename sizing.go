@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNoGrowth is returned if the new size of a volume isn't larger than the
+// current one, e.g. if the volume already has the absolute size. Such volumes
+// are skipped.
+var errNoGrowth = errors.New("The volume doesn't have to grow")
+
+// SizingPolicy tells how to compute the new size of a volume in GiB. Size
+// and Add override Percents. Zero values aren't applied.
+type SizingPolicy struct {
+	Percents     int64
+	Size         int64
+	Add          int64
+	MinIncrement int64
+	MaxIncrement int64
+	Step         int64
+}
+
+// newSizingPolicy creates the sizing policy from the flags.
+func newSizingPolicy() *SizingPolicy {
+	return &SizingPolicy{
+		Percents:     *percents,
+		Size:         *targetSize,
+		Add:          *addSize,
+		MinIncrement: *minIncrement,
+		MaxIncrement: *maxIncrement,
+		Step:         *sizeStep,
+	}
+}
+
+// Validate returns an error if the policy is inconsistent.
+func (policy *SizingPolicy) Validate() error {
+	if policy.Size != 0 && policy.Add != 0 {
+		return fmt.Errorf("Only one of size and add can be defined")
+	}
+	if policy.Percents < 0 || policy.Size < 0 || policy.Add < 0 || policy.Step < 0 {
+		return fmt.Errorf("percents, size, add and step can't be negative")
+	}
+	if policy.MinIncrement < 0 || policy.MaxIncrement < 0 || (policy.MaxIncrement > 0 && policy.MinIncrement > policy.MaxIncrement) {
+		return fmt.Errorf("Wrong increment range %d-%d", policy.MinIncrement, policy.MaxIncrement)
+	}
+	return nil
+}
+
+// NewSize returns the new size in GiB of a volume of currentSize GiB. The
+// increment is clamped to the minimum and the maximum, then the new size is
+// rounded up to a multiple of the step. The new size never exceeds the
+// absolute size or the maximum increment: if the minimum increment or the
+// rounding exceeds them, the size is rounded down to a multiple of the step
+// as long as the volume still grows, otherwise it's set to the bound. The
+// current size is returned if the volume doesn't have to grow, e.g. if it
+// isn't smaller than the absolute size.
+func (policy *SizingPolicy) NewSize(currentSize int64) int64 {
+	var increment int64
+	switch {
+	case policy.Size > 0:
+		increment = policy.Size - currentSize
+	case policy.Add > 0:
+		increment = policy.Add
+	default:
+		increment = percentageIncrease(currentSize, policy.Percents)
+	}
+	if increment <= 0 {
+		return currentSize
+	}
+
+	increment = clamp(increment, policy.MinIncrement, policy.MaxIncrement)
+	newSize := currentSize + increment
+
+	if policy.Step > 0 && newSize%policy.Step != 0 {
+		newSize = (newSize/policy.Step + 1) * policy.Step
+	}
+
+	if maxSize := policy.maxSize(currentSize); maxSize > 0 && newSize > maxSize {
+		newSize = maxSize
+		if policy.Step > 0 && maxSize/policy.Step*policy.Step > currentSize {
+			newSize = maxSize / policy.Step * policy.Step
+		}
+	}
+
+	return newSize
+}
+
+// maxSize returns the largest size in GiB a volume of currentSize GiB may
+// grow to, or 0 if there's no limit.
+func (policy *SizingPolicy) maxSize(currentSize int64) int64 {
+	maxSize := policy.Size
+	if policy.MaxIncrement > 0 && (maxSize == 0 || currentSize+policy.MaxIncrement < maxSize) {
+		maxSize = currentSize + policy.MaxIncrement
+	}
+	return maxSize
+}
+
+// Increment returns the number of GiB to add to a volume of currentSize GiB.
+func (policy *SizingPolicy) Increment(currentSize int64) int64 {
+	return policy.NewSize(currentSize) - currentSize
+}
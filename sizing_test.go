@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSizingPolicyNewSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      SizingPolicy
+		currentSize int64
+		expected    int64
+	}{
+		{"percents", SizingPolicy{Percents: 20}, 100, 120},
+		{"percents rounded up", SizingPolicy{Percents: 20}, 11, 14},
+		{"size", SizingPolicy{Size: 150}, 100, 150},
+		{"size not smaller", SizingPolicy{Size: 100}, 120, 120},
+		{"add", SizingPolicy{Add: 30}, 100, 130},
+		{"min increment", SizingPolicy{Percents: 1, MinIncrement: 10}, 100, 110},
+		{"max increment", SizingPolicy{Percents: 50, MaxIncrement: 10}, 100, 110},
+		{"min increment capped at size", SizingPolicy{Size: 100, MinIncrement: 50}, 90, 100},
+		{"min increment capped at size with step", SizingPolicy{Size: 100, MinIncrement: 50, Step: 30}, 80, 90},
+		{"step", SizingPolicy{Percents: 20, Step: 50}, 120, 150},
+		{"step exact", SizingPolicy{Add: 30, Step: 50}, 120, 150},
+		{"step rounded down to max increment", SizingPolicy{Percents: 20, MaxIncrement: 40, Step: 50}, 110, 150},
+		{"step capped at max increment", SizingPolicy{Percents: 20, MaxIncrement: 10, Step: 50}, 120, 130},
+		{"step rounded down to size", SizingPolicy{Size: 140, Step: 50}, 80, 100},
+		{"step capped at size", SizingPolicy{Size: 140, Step: 50}, 120, 140},
+	}
+
+	for _, test := range tests {
+		newSize := test.policy.NewSize(test.currentSize)
+		if newSize != test.expected {
+			t.Errorf("%s: expected %d GiB, got %d", test.name, test.expected, newSize)
+			continue
+		}
+		if test.policy.MaxIncrement > 0 && newSize-test.currentSize > test.policy.MaxIncrement {
+			t.Errorf("%s: %d GiB exceeds the maximum increment", test.name, newSize)
+		}
+		if test.policy.Size > 0 && newSize > test.policy.Size && newSize != test.currentSize {
+			t.Errorf("%s: %d GiB exceeds the size", test.name, newSize)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ResolveFilesystemUUID returns the path of the device containing the
+// filesystem with the UUID. It's found by the udev symlinks in
+// <dev-path>/disk/by-uuid.
+func ResolveFilesystemUUID(uuid string) (string, error) {
+	return resolveDiskLink("by-uuid", uuid)
+}
+
+// ResolveFilesystemLabel returns the path of the device containing the
+// filesystem with the label. It's found by the udev symlinks in
+// <dev-path>/disk/by-label.
+func ResolveFilesystemLabel(label string) (string, error) {
+	return resolveDiskLink("by-label", udevEncodeString(label))
+}
+
+func resolveDiskLink(directory, name string) (string, error) {
+	link := filepath.Join(*hostDevPath, "disk", directory, name)
+
+	devicePath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't resolve \"%s\": %s", link, err)
+	}
+	log.Infof("\"%s\" refers to the device \"%s\".", link, devicePath)
+
+	return devicePath, nil
+}
+
+// udevEncodeString escapes the characters udev doesn't allow in names of
+// symlinks as \xHH, like udev_util_encode_string does. E.g. the label
+// "my data" becomes "my\x20data".
+func udevEncodeString(value string) string {
+	var builder strings.Builder
+	for index := 0; index < len(value); index++ {
+		character := value[index]
+		switch {
+		case character >= '0' && character <= '9', character >= 'A' && character <= 'Z', character >= 'a' && character <= 'z',
+			strings.IndexByte("#+-.:=@_", character) >= 0, character >= 0x80:
+			builder.WriteByte(character)
+		default:
+			fmt.Fprintf(&builder, "\\x%02x", character)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUDevEncodeString(t *testing.T) {
+	tests := map[string]string{
+		"data":         "data",
+		"my data":      `my\x20data`,
+		"db/primary":   `db\x2fprimary`,
+		"#+-.:=@_":     "#+-.:=@_",
+		`back\slash`:   `back\x5cslash`,
+		"tab\tnewline": `tab\x09newline`,
+		"données":      "données",
+		"データ":          "データ",
+	}
+
+	for value, expected := range tests {
+		if encoded := udevEncodeString(value); encoded != expected {
+			t.Errorf("\"%s\": expected \"%s\", got \"%s\"", value, expected, encoded)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DeviceKind is the kind of a block device in a storage stack.
+type DeviceKind string
+
+const (
+	DeviceKindPartition DeviceKind = "partition"
+	// DeviceKindDMLinear is an LVM logical volume, which maps linear or
+	// striped ranges of its physical volumes.
+	DeviceKindDMLinear DeviceKind = "dm-linear"
+	DeviceKindDMCrypt  DeviceKind = "dm-crypt"
+	// DeviceKindDM is any other device mapper device, including thin, raid,
+	// cache and mirror LVM logical volumes.
+	DeviceKindDM       DeviceKind = "dm"
+	DeviceKindMD       DeviceKind = "md"
+	DeviceKindLoop     DeviceKind = "loop"
+	DeviceKindNVMeDisk DeviceKind = "nvme-disk"
+	DeviceKindXVDDisk  DeviceKind = "xvd-disk"
+	// DeviceKindVirtioDisk is a virtio disk, e.g. of a virtual machine
+	// outside EC2.
+	DeviceKindVirtioDisk DeviceKind = "virtio-disk"
+	// DeviceKindDisk is any other disk, e.g. a SCSI one.
+	DeviceKindDisk DeviceKind = "disk"
+)
+
+// BlockDevice is a node of the storage stack. Its children are the devices
+// it's built on, so disks are the leaves of the graph.
+type BlockDevice struct {
+	// Name is the kernel name of the device, e.g. nvme1n1p1 or dm-0.
+	Name  string
+	Kind  DeviceKind
+	Major uint32
+	Minor uint32
+	// Size is the size of the device in bytes.
+	Size int64
+	// SysPath is the directory of the device in sysfs.
+	SysPath string
+	// PartitionNumber is set for partitions.
+	PartitionNumber int
+	// MapperName and MapperUUID are set for device mapper devices.
+	MapperName string
+	MapperUUID string
+	// RAIDLevel is set for md arrays, e.g. raid1.
+	RAIDLevel string
+	// BackingFile is set for attached loop devices.
+	BackingFile string
+	// Model is the model of disks, if sysfs reports it, e.g. "Amazon
+	// Elastic Block Store" for EBS volumes attached as NVMe devices.
+	Model    string
+	Children []*BlockDevice
+}
+
+// MajorMinor returns the device number in the major:minor notation.
+func (device *BlockDevice) MajorMinor() string {
+	return fmt.Sprintf("%d:%d", device.Major, device.Minor)
+}
+
+// Path returns the path of the device file.
+func (device *BlockDevice) Path() string {
+	return filepath.Join(*hostDevPath, device.Name)
+}
+
+// IsDisk reports whether the device is a whole disk.
+func (device *BlockDevice) IsDisk() bool {
+	switch device.Kind {
+	case DeviceKindNVMeDisk, DeviceKindXVDDisk, DeviceKindVirtioDisk, DeviceKindDisk:
+		return true
+	}
+	return false
+}
+
+// Walk calls the function for the device and every device under it, children
+// before parents. Devices shared by several parents are visited once.
+func (device *BlockDevice) Walk(visit func(device *BlockDevice) error) error {
+	return walkBlockDevices([]*BlockDevice{device}, make(map[*BlockDevice]bool), visit)
+}
+
+// Disks returns the disks the device is located on.
+func (device *BlockDevice) Disks() []*BlockDevice {
+	var disks []*BlockDevice
+	device.Walk(func(child *BlockDevice) error {
+		if child.IsDisk() {
+			disks = append(disks, child)
+		}
+		return nil
+	})
+	return disks
+}
+
+// IsLogicalVolume reports whether the device is an LVM logical volume of any
+// layout.
+func (device *BlockDevice) IsLogicalVolume() bool {
+	return strings.HasPrefix(device.MapperUUID, lvmUUIDPrefix)
+}
+
+// findChild returns the direct child with the major:minor number or nil.
+func (device *BlockDevice) findChild(majorMinor string) *BlockDevice {
+	for _, child := range device.Children {
+		if child.MajorMinor() == majorMinor {
+			return child
+		}
+	}
+	return nil
+}
+
+func walkBlockDevices(devices []*BlockDevice, visited map[*BlockDevice]bool, visit func(device *BlockDevice) error) error {
+	for _, device := range devices {
+		if visited[device] {
+			continue
+		}
+		visited[device] = true
+
+		if err := walkBlockDevices(device.Children, visited, visit); err != nil {
+			return err
+		}
+		if err := visit(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StorageStack is the graph of block devices under a filesystem.
+type StorageStack struct {
+	// Mount is nil if the filesystem isn't mounted.
+	Mount *MountInfo
+	// Devices are the devices the filesystem is located on. Multi-device
+	// btrfs filesystems have several of them.
+	Devices []*BlockDevice
+}
+
+// String returns the mount point of the stack or the path of its device if
+// the filesystem isn't mounted.
+func (stack *StorageStack) String() string {
+	if stack.Mount != nil {
+		return stack.Mount.MountPoint
+	}
+	return stack.Devices[0].Path()
+}
+
+// Walk calls the function for every device of the stack, children before
+// parents. Devices shared by several parents are visited once.
+func (stack *StorageStack) Walk(visit func(device *BlockDevice) error) error {
+	return walkBlockDevices(stack.Devices, make(map[*BlockDevice]bool), visit)
+}
+
+// Disks returns the disks of the stack.
+func (stack *StorageStack) Disks() []*BlockDevice {
+	return stack.DevicesOfKind(DeviceKindNVMeDisk, DeviceKindXVDDisk, DeviceKindVirtioDisk, DeviceKindDisk)
+}
+
+// Leaves returns the devices of the stack without children. These are
+// usually disks, but may be loop devices or device mapper devices without
+// underlying devices as well.
+func (stack *StorageStack) Leaves() []*BlockDevice {
+	var leaves []*BlockDevice
+	stack.Walk(func(device *BlockDevice) error {
+		if len(device.Children) == 0 {
+			leaves = append(leaves, device)
+		}
+		return nil
+	})
+	return leaves
+}
+
+// DevicesOfKind returns the devices of the stack of the given kinds,
+// children before parents.
+func (stack *StorageStack) DevicesOfKind(kinds ...DeviceKind) []*BlockDevice {
+	var devices []*BlockDevice
+	stack.Walk(func(device *BlockDevice) error {
+		for _, kind := range kinds {
+			if device.Kind == kind {
+				devices = append(devices, device)
+			}
+		}
+		return nil
+	})
+	return devices
+}
+
+// BuildStorageStack finds the filesystem containing the path and reads the
+// graph of block devices under it from sysfs.
+func BuildStorageStack(mountPoint string) (*StorageStack, error) {
+	mount, err := findMountOfPath(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("The path \"%s\" belongs to the filesystem mounted on \"%s\".", mountPoint, mount.MountPoint)
+
+	return buildMountStorageStack(mount)
+}
+
+// buildMountStorageStack reads the graph of block devices under the mounted
+// filesystem.
+func buildMountStorageStack(mount *MountInfo) (*StorageStack, error) {
+	if !strings.HasPrefix(mount.Source, "/dev/") {
+		return nil, fmt.Errorf("\"%s\" mounted on \"%s\" is not a device file", mount.Source, mount.MountPoint)
+	}
+	log.Infof("Found the device \"%s\" with %s filesystem matching the mount point \"%s\".", mount.Source, mount.FSType, mount.MountPoint)
+
+	devicesPathsList, err := getMountDevicesSysPaths(mount)
+	if err != nil {
+		return nil, err
+	}
+
+	stack := &StorageStack{Mount: mount}
+	builder := &topologyBuilder{devices: make(map[string]*BlockDevice)}
+	for _, deviceSysPath := range devicesPathsList {
+		device, err := builder.build(deviceSysPath)
+		if err != nil {
+			return nil, err
+		}
+		stack.Devices = append(stack.Devices, device)
+	}
+
+	return stack, nil
+}
+
+// BuildDeviceStorageStack reads the graph of block devices under the device
+// file. The filesystem mounted from the device is looked up in mountinfo,
+// Mount of the stack is nil if the device isn't mounted.
+func BuildDeviceStorageStack(devicePath string) (*StorageStack, error) {
+	deviceSysPath, err := getDeviceFileSysPath(devicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := ReadBlockDevice(deviceSysPath)
+	if err != nil {
+		return nil, err
+	}
+	stack := &StorageStack{Devices: []*BlockDevice{device}}
+
+	mounts, err := ReadMountInfo(mountInfoPath())
+	if err != nil {
+		return nil, err
+	}
+	for _, mount := range DeviceMounts(mounts) {
+		if mount.Major == device.Major && mount.Minor == device.Minor {
+			stack.Mount = mount
+		}
+	}
+
+	if stack.Mount != nil {
+		log.Infof("The device \"%s\" is mounted on \"%s\".", devicePath, stack.Mount.MountPoint)
+	} else {
+		log.Infof("The device \"%s\" isn't mounted.", devicePath)
+	}
+
+	return stack, nil
+}
+
+// ReadBlockDevice reads the device located at the sysfs path and all devices
+// under it.
+func ReadBlockDevice(deviceSysPath string) (*BlockDevice, error) {
+	builder := &topologyBuilder{devices: make(map[string]*BlockDevice)}
+	return builder.build(deviceSysPath)
+}
+
+// topologyBuilder reads devices from sysfs. Devices are cached by their
+// sysfs paths, so devices shared by several parents, such as the disk of
+// several partitions, are the same nodes of the graph.
+type topologyBuilder struct {
+	devices map[string]*BlockDevice
+}
+
+func (builder *topologyBuilder) build(deviceSysPath string) (*BlockDevice, error) {
+	deviceLink, err := filepath.EvalSymlinks(deviceSysPath)
+	if err != nil {
+		return nil, err
+	}
+	if device, ok := builder.devices[deviceLink]; ok {
+		return device, nil
+	}
+
+	device := &BlockDevice{
+		Name:    filepath.Base(deviceLink),
+		SysPath: deviceLink,
+	}
+
+	if device.Major, device.Minor, err = readDeviceNumbers(deviceLink); err != nil {
+		return nil, err
+	}
+	if device.Size, err = readBlockDeviceSize(deviceLink); err != nil {
+		return nil, err
+	}
+
+	// Partitions are located in the directory of their disk in sysfs.
+	partition, err := readPartition(deviceLink)
+	if err != nil {
+		return nil, err
+	}
+	if partition != nil {
+		device.Kind = DeviceKindPartition
+		device.PartitionNumber = partition.Number
+
+		disk, err := builder.build(filepath.Dir(deviceLink))
+		if err != nil {
+			return nil, err
+		}
+		device.Children = []*BlockDevice{disk}
+	} else {
+		if err := device.readKind(); err != nil {
+			return nil, err
+		}
+
+		slaves, err := ioutil.ReadDir(filepath.Join(deviceLink, "slaves"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, slave := range slaves {
+			child, err := builder.build(filepath.Join(deviceLink, "slaves", slave.Name()))
+			if err != nil {
+				return nil, err
+			}
+			device.Children = append(device.Children, child)
+		}
+
+		// Without dmsetup the layout of logical volumes is guessed from
+		// their devices: thin, raid, cache and mirror logical volumes are
+		// built on hidden logical volumes instead of physical volumes.
+		if device.Kind == DeviceKindDMLinear {
+			for _, child := range device.Children {
+				if child.IsLogicalVolume() {
+					device.Kind = DeviceKindDM
+				}
+			}
+		}
+	}
+	log.Debugf("Found %s device \"%s\" (%s) of %d bytes.", device.Kind, device.Name, device.MajorMinor(), device.Size)
+
+	builder.devices[deviceLink] = device
+	return device, nil
+}
+
+// readKind detects the kind of the device, which isn't a partition, by the
+// subdirectories device mapper, md and loop devices have in sysfs.
+func (device *BlockDevice) readKind() error {
+	uuid, err := readSysfsAttribute(filepath.Join(device.SysPath, "dm", "uuid"))
+	if err == nil {
+		device.MapperUUID = uuid
+		if device.MapperName, err = readSysfsAttribute(filepath.Join(device.SysPath, "dm", "name")); err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(uuid, lvmUUIDPrefix):
+			device.Kind = device.readLogicalVolumeKind()
+		case strings.HasPrefix(uuid, cryptUUIDPrefix):
+			device.Kind = DeviceKindDMCrypt
+		default:
+			device.Kind = DeviceKindDM
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	level, err := readSysfsAttribute(filepath.Join(device.SysPath, "md", "level"))
+	if err == nil {
+		device.Kind = DeviceKindMD
+		device.RAIDLevel = level
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(device.Name, "loop"):
+		device.Kind = DeviceKindLoop
+		// The backing file is only there while the loop device is attached.
+		if backingFile, err := readSysfsAttribute(filepath.Join(device.SysPath, "loop", "backing_file")); err == nil {
+			device.BackingFile = backingFile
+		}
+	case strings.HasPrefix(device.Name, "nvme"):
+		device.Kind = DeviceKindNVMeDisk
+	case strings.HasPrefix(device.Name, "xvd"):
+		device.Kind = DeviceKindXVDDisk
+	case isVirtioDisk(device.SysPath):
+		device.Kind = DeviceKindVirtioDisk
+	default:
+		device.Kind = DeviceKindDisk
+	}
+
+	if device.IsDisk() {
+		// The model is missing for some disks, e.g. Xen ones.
+		device.Model, _ = readSysfsAttribute(filepath.Join(device.SysPath, "device", "model"))
+	}
+
+	return nil
+}
+
+// readLogicalVolumeKind returns the kind of the LVM logical volume by the
+// target types of its device mapper table. Only logical volumes mapping
+// linear or striped ranges are dm-linear ones.
+func (device *BlockDevice) readLogicalVolumeKind() DeviceKind {
+	table, err := commandExecutor.Execute("dmsetup", "table", device.MapperName)
+	if err != nil {
+		log.Debugf("The device mapper table of \"%s\" can't be read: %s", device.MapperName, err)
+		return DeviceKindDMLinear
+	}
+	targets, err := ParseDMTable(table)
+	if err != nil {
+		log.Debugln(err)
+		return DeviceKindDMLinear
+	}
+
+	for _, target := range targets {
+		if target.Type != "linear" && target.Type != "striped" {
+			return DeviceKindDM
+		}
+	}
+	return DeviceKindDMLinear
+}
+
+// isVirtioDisk reports whether the disk located at the sysfs path is driven
+// by virtio_blk.
+func isVirtioDisk(deviceSysPath string) bool {
+	driver, err := filepath.EvalSymlinks(filepath.Join(deviceSysPath, "device", "driver"))
+	if err != nil {
+		return strings.HasPrefix(filepath.Base(deviceSysPath), "vd")
+	}
+	return filepath.Base(driver) == "virtio_blk"
+}
+
+// readDeviceNumbers reads the major:minor number from the dev file of the
+// device located at the sysfs path.
+func readDeviceNumbers(deviceSysPath string) (uint32, uint32, error) {
+	dev, err := readSysfsAttribute(filepath.Join(deviceSysPath, "dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	majorMinor := strings.Split(dev, ":")
+	if len(majorMinor) != 2 {
+		return 0, 0, fmt.Errorf("Wrong device number \"%s\" of \"%s\"", dev, deviceSysPath)
+	}
+	major, err := strconv.ParseUint(majorMinor[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Wrong device number \"%s\" of \"%s\"", dev, deviceSysPath)
+	}
+	minor, err := strconv.ParseUint(majorMinor[1], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Wrong device number \"%s\" of \"%s\"", dev, deviceSysPath)
+	}
+
+	return uint32(major), uint32(minor), nil
+}
+
+// readSysfsAttribute returns the contents of the sysfs file without the
+// trailing newline.
+func readSysfsAttribute(path string) (string, error) {
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"strconv"
 	"sync"
@@ -11,7 +12,6 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	volumesnapshotv1beta1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -28,9 +28,9 @@ const (
 	volumeSnapshotAPIVersion = "snapshot.storage.k8s.io/v1beta1"
 )
 
-// EnlargePVC increases Kubernetes Persistent Volume size by the specified
-// percentage.
-func EnlargePVC(pvc string, namespace string, percents *int64, createSnapshot, dryRun, waitForModifying *bool) error {
+// EnlargePVC increases Kubernetes Persistent Volume size as the sizing
+// policy specifies.
+func EnlargePVC(pvc string, namespace string, sizing *SizingPolicy, createSnapshot, dryRun, waitForModifying *bool) error {
 	// Create k8s in-cluster config.
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -55,6 +55,20 @@ func EnlargePVC(pvc string, namespace string, percents *int64, createSnapshot, d
 
 	log.Debugln("PVC metadata:", pvcMetadata)
 
+	// EBS Volume size fits GB.
+	currentSizeInB, _ := pvcMetadata.Spec.Resources.Requests.Storage().AsInt64()
+	currentSizeInGB := currentSizeInB / 1073741824
+	log.Infof("Current size of the volume: %d GB", currentSizeInGB)
+
+	// The new size is checked before the snapshot is created.
+	newSizeInGB := sizing.NewSize(currentSizeInGB)
+	if newSizeInGB <= currentSizeInGB {
+		log.Infof("New size of the PVC \"%s\" (%d GB) isn't larger than the current one (%d GB), so it isn't enlarged.", pvc, newSizeInGB, currentSizeInGB)
+		return nil
+	}
+	newSize := strconv.FormatInt(newSizeInGB, 10)
+	log.Infof("New volume size after the enlargement: %s GB", newSize)
+
 	if *createSnapshot {
 		log.Infoln("Creating snapshot for the volume...")
 
@@ -109,14 +123,6 @@ func EnlargePVC(pvc string, namespace string, percents *int64, createSnapshot, d
 		log.Debugln("VolumeSnapshot metadata:", createdVolumeSnapshot)
 	}
 
-	// EBS Volume size fits GB.
-	currentSizeInB, _ := pvcMetadata.Spec.Resources.Requests.Storage().AsInt64()
-	currentSizeInGB := currentSizeInB / 1073741824
-	log.Infof("Current size of the volume: %d GB", currentSizeInGB)
-
-	newSize := strconv.FormatInt(currentSizeInGB+percentageIncrease(currentSizeInGB, *percents), 10)
-	log.Infof("New volume size after the enlargement: %s GB", newSize)
-
 	// Enlarge PVC
 	var dryRunOption []string
 	if *dryRun {
@@ -149,14 +155,103 @@ func EnlargePVC(pvc string, namespace string, percents *int64, createSnapshot, d
 	return nil
 }
 
-// EnlargeVolumeByID increases the disk size by the specified percentage.
-func EnlargeVolumeByID(volumeID *string, percents *int64, createSnapshot, dryRun, waitForModifying *bool) error {
+// EnlargeVolumeByID increases the disk size as the sizing policy specifies.
+// It returns the new size of the volume in GB.
+func EnlargeVolumeByID(volumeID *string, sizing *SizingPolicy, createSnapshot, dryRun, waitForModifying *bool) (int64, error) {
+	return enlargeVolume(volumeID, sizing.NewSize, createSnapshot, dryRun, waitForModifying)
+}
+
+// EnlargeVolumeByIDBy increases the disk size by the specified number of GB.
+// It returns the new size of the volume in GB.
+func EnlargeVolumeByIDBy(volumeID *string, increment int64, createSnapshot, dryRun, waitForModifying *bool) (int64, error) {
+	newSize := func(currentSize int64) int64 {
+		return currentSize + increment
+	}
+	return enlargeVolume(volumeID, newSize, createSnapshot, dryRun, waitForModifying)
+}
+
+// EnlargeVolumeByIDTo sets the disk size to the specified number of GB.
+// It returns the new size of the volume in GB.
+func EnlargeVolumeByIDTo(volumeID *string, size int64, createSnapshot, dryRun, waitForModifying *bool) (int64, error) {
+	newSize := func(currentSize int64) int64 {
+		return size
+	}
+	return enlargeVolume(volumeID, newSize, createSnapshot, dryRun, waitForModifying)
+}
+
+// enlargeVolume sets the disk size to the result of newSize called with the
+// current size of the volume in GB. Callers check the modification cooldown
+// of all volumes to be enlarged before. If the new size isn't larger, the
+// current size is returned with errNoGrowth.
+func enlargeVolume(volumeID *string, newSize func(currentSize int64) int64, createSnapshot, dryRun, waitForModifying *bool) (int64, error) {
 	log.Debugln("Current EBS volume ID:", *volumeID)
 
-	awsEc2Client := ec2.New(session.New())
+	awsEc2Client, err := newEC2Client()
+	if err != nil {
+		return 0, err
+	}
 	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), 15*time.Minute)
 	defer cancel()
 
+	volumesFilters := &ec2.DescribeVolumesInput{
+		VolumeIds: []*string{volumeID},
+	}
+
+	volumeInfo, err := awsEc2Client.DescribeVolumes(volumesFilters)
+
+	if err != nil {
+		return 0, err
+	}
+
+	log.Debugf("Current size of the EBS volume: %d GB", *volumeInfo.Volumes[0].Size)
+
+	// The new size is checked before the snapshot is created.
+	newVolumeSize := newSize(*volumeInfo.Volumes[0].Size)
+	log.Debugf("New EBS volume size after the enlargement: %d GB", newVolumeSize)
+
+	if newVolumeSize <= *volumeInfo.Volumes[0].Size {
+		log.Infof("New size of the EBS volume %s (%d GB) isn't larger than the current one (%d GB), so it isn't enlarged.", *volumeID, newVolumeSize, *volumeInfo.Volumes[0].Size)
+		return *volumeInfo.Volumes[0].Size, errNoGrowth
+	}
+
+	modifiedVolume := &ec2.ModifyVolumeInput{
+		DryRun:   dryRun,
+		Size:     &newVolumeSize,
+		VolumeId: volumeID,
+	}
+
+	// The type, IOPS and throughput are changed by the same modification to
+	// avoid a second cooldown.
+	volume := volumeInfo.Volumes[0]
+	currentProfile := VolumeProfile{
+		VolumeType: aws.StringValue(volume.VolumeType),
+		Size:       *volume.Size,
+		VolumePerformance: VolumePerformance{
+			Iops:       aws.Int64Value(volume.Iops),
+			Throughput: aws.Int64Value(volume.Throughput),
+		},
+	}
+	newProfile, err := newPerformancePolicy().PlanVolumeProfile(currentProfile, newVolumeSize, *targetVolumeType)
+	if err != nil {
+		return 0, fmt.Errorf("EBS volume %s can't be enlarged: %w", *volumeID, err)
+	}
+	log.Infof("EBS volume %s will be changed from %s to %s.", *volumeID, currentProfile, newProfile)
+
+	typeChanged := newProfile.VolumeType != currentProfile.VolumeType
+	if typeChanged {
+		modifiedVolume.VolumeType = aws.String(newProfile.VolumeType)
+	}
+	// IOPS and throughput are only sent if the plan changes them. After a type
+	// change they're always sent, otherwise EBS applies the defaults of the
+	// new type, e.g. 3000 IOPS to a gp2 volume converted to gp3.
+	limits := ebsVolumeTypeLimits[newProfile.VolumeType]
+	if limits.MaxIops > 0 && (typeChanged || newProfile.Iops != currentProfile.Iops) {
+		modifiedVolume.Iops = aws.Int64(newProfile.Iops)
+	}
+	if limits.MaxThroughput > 0 && (typeChanged || newProfile.Throughput != currentProfile.Throughput) {
+		modifiedVolume.Throughput = aws.Int64(newProfile.Throughput)
+	}
+
 	if *createSnapshot {
 		log.Infoln("Creating snapshot for the volume...")
 
@@ -165,7 +260,7 @@ func EnlargeVolumeByID(volumeID *string, percents *int64, createSnapshot, dryRun
 		}
 		snapshot, err := awsEc2Client.CreateSnapshotWithContext(ctx, snapshotFilter)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		log.Infoln("ID of the snapshot to be created:", *snapshot.SnapshotId)
@@ -176,36 +271,15 @@ func EnlargeVolumeByID(volumeID *string, percents *int64, createSnapshot, dryRun
 		log.Infoln("Waiting for the volume snapshot to complete...")
 		err = awsEc2Client.WaitUntilSnapshotCompletedWithContext(ctx, snapshotInput)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		log.Infoln("Snapshot creation completed.")
 	}
 
-	volumesFilters := &ec2.DescribeVolumesInput{
-		VolumeIds: []*string{volumeID},
-	}
-
-	volumeInfo, err := awsEc2Client.DescribeVolumes(volumesFilters)
-
-	if err != nil {
-		return err
-	}
-
-	log.Debugf("Current size of the EBS volume: %d GB", *volumeInfo.Volumes[0].Size)
-
-	newSize := *volumeInfo.Volumes[0].Size + percentageIncrease(*volumeInfo.Volumes[0].Size, *percents)
-	log.Debugf("New EBS volume size after the enlargement: %d GB", newSize)
-
-	modifiedVolume := &ec2.ModifyVolumeInput{
-		DryRun:   dryRun,
-		Size:     &newSize,
-		VolumeId: volumeID,
-	}
-
 	_, err = awsEc2Client.ModifyVolume(modifiedVolume)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	log.Infoln("Volume enlargement started.")
@@ -214,12 +288,12 @@ func EnlargeVolumeByID(volumeID *string, percents *int64, createSnapshot, dryRun
 		log.Infoln("Waiting for the volume enlargement to complete...")
 		err = ebsWaitForModifying(ctx, volumeID, awsEc2Client)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		log.Infoln("Enlargement completed.")
 	}
 
-	return nil
+	return newVolumeSize, nil
 }
 
 func ebsWaitForModifying(ctx context.Context, volumeID *string, awsEc2Client *ec2.EC2) error {
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEnlargeVolumeByID(t *testing.T) {
+	const instanceID = "i-0123456789abcdef0"
+	createSnapshot, dryRun, waitForModifying := true, false, false
+
+	tests := []struct {
+		name     string
+		sizing   *SizingPolicy
+		expected int64
+		err      error
+		actions  []string
+	}{
+		// The snapshot isn't created for a volume, which doesn't grow.
+		{"not growing", &SizingPolicy{Size: 50}, 100, errNoGrowth, []string{"DescribeVolumes"}},
+		{"growing", &SizingPolicy{Size: 150}, 150, nil, []string{"DescribeVolumes", "CreateSnapshot", "DescribeSnapshots", "ModifyVolume"}},
+	}
+
+	for _, test := range tests {
+		fake := &fakeAWS{
+			InstanceID: instanceID,
+			Responses: map[string]string{
+				"DescribeVolumes":   describeVolumesResponse(instanceID, "vol-data 100 /dev/sdf"),
+				"CreateSnapshot":    `<CreateSnapshotResponse><snapshotId>snap-data</snapshotId></CreateSnapshotResponse>`,
+				"DescribeSnapshots": `<DescribeSnapshotsResponse><snapshotSet><item><snapshotId>snap-data</snapshotId><status>completed</status></item></snapshotSet></DescribeSnapshotsResponse>`,
+				"ModifyVolume":      `<ModifyVolumeResponse><volumeModification><volumeId>vol-data</volumeId></volumeModification></ModifyVolumeResponse>`,
+			},
+		}
+		useFakeAWS(t, fake)
+
+		volumeID := "vol-data"
+		size, err := EnlargeVolumeByID(&volumeID, test.sizing, &createSnapshot, &dryRun, &waitForModifying)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected the error %v, got %v", test.name, test.err, err)
+		}
+		if size != test.expected {
+			t.Errorf("%s: expected %d GB, got %d", test.name, test.expected, size)
+		}
+		if !reflect.DeepEqual(fake.Actions, test.actions) {
+			t.Errorf("%s: expected EC2 actions %v, got %v", test.name, test.actions, fake.Actions)
+		}
+	}
+}
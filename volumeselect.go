@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ParseVolumeIDs splits the comma-separated list of EBS volume IDs and
+// removes duplicates.
+func ParseVolumeIDs(list string) ([]string, error) {
+	var volumeIDsList []string
+	seen := make(map[string]bool)
+	for _, volumeID := range strings.Split(list, ",") {
+		volumeID = strings.TrimSpace(volumeID)
+		if volumeID == "" {
+			continue
+		}
+		if !strings.HasPrefix(volumeID, "vol-") {
+			return nil, fmt.Errorf("\"%s\" is not an EBS volume ID", volumeID)
+		}
+		if !seen[volumeID] {
+			seen[volumeID] = true
+			volumeIDsList = append(volumeIDsList, volumeID)
+		}
+	}
+
+	if len(volumeIDsList) == 0 {
+		return nil, fmt.Errorf("No EBS volume IDs in \"%s\"", list)
+	}
+
+	return volumeIDsList, nil
+}
+
+// ParseVolumeFilter parses a comma-separated list of DescribeVolumes filters
+// in the name=value form, e.g. "tag:team=db,volume-type=gp3". Values of
+// filters with the same name are combined, so a volume matching any of them
+// matches the filter.
+func ParseVolumeFilter(filter string) ([]*ec2.Filter, error) {
+	var filters []*ec2.Filter
+	filtersByName := make(map[string]*ec2.Filter)
+	for _, item := range strings.Split(filter, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		nameValue := strings.SplitN(item, "=", 2)
+		name := strings.TrimSpace(nameValue[0])
+		if len(nameValue) != 2 || name == "" {
+			return nil, fmt.Errorf("Wrong volume filter \"%s\", it must be name=value", item)
+		}
+		value := strings.TrimSpace(nameValue[1])
+		if value == "" {
+			return nil, fmt.Errorf("Wrong volume filter \"%s\", its value is empty", item)
+		}
+
+		if existingFilter, ok := filtersByName[name]; ok {
+			existingFilter.Values = append(existingFilter.Values, aws.String(value))
+			continue
+		}
+		filtersByName[name] = &ec2.Filter{
+			Name:   aws.String(name),
+			Values: []*string{aws.String(value)},
+		}
+		filters = append(filters, filtersByName[name])
+	}
+
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("Empty volume filter")
+	}
+
+	return filters, nil
+}
+
+// GetEBSVolumeIDsByFilter returns the IDs of EBS volumes matching the
+// DescribeVolumes filters.
+func GetEBSVolumeIDsByFilter(filters []*ec2.Filter) ([]string, error) {
+	svc, err := newEC2Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeIDsList []string
+	err = svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{
+		Filters: filters,
+	}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			volumeIDsList = append(volumeIDsList, aws.StringValue(volume.VolumeId))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return volumeIDsList, nil
+}
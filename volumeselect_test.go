@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseVolumeIDs(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+		fails    bool
+	}{
+		{"vol-0123456789abcdef0", []string{"vol-0123456789abcdef0"}, false},
+		{" vol-1 , vol-2,,vol-1 ", []string{"vol-1", "vol-2"}, false},
+		{"vol-1,i-0123456789abcdef0", nil, true},
+		{" , ", nil, true},
+		{"", nil, true},
+	}
+
+	for _, test := range tests {
+		volumeIDsList, err := ParseVolumeIDs(test.list)
+		if test.fails {
+			if err == nil {
+				t.Errorf("\"%s\": expected an error, got %v", test.list, volumeIDsList)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("\"%s\": %s", test.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(volumeIDsList, test.expected) {
+			t.Errorf("\"%s\": expected %v, got %v", test.list, test.expected, volumeIDsList)
+		}
+	}
+}
+
+func TestParseVolumeFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected map[string][]string
+		fails    bool
+	}{
+		{"tag:team=db", map[string][]string{"tag:team": {"db"}}, false},
+		{"tag:team=db, volume-type=gp3,tag:team=web", map[string][]string{"tag:team": {"db", "web"}, "volume-type": {"gp3"}}, false},
+		{"tag:expression=a=b", map[string][]string{"tag:expression": {"a=b"}}, false},
+		{"=db", nil, true},
+		{"tag:team", nil, true},
+		{"tag:team=", nil, true},
+		{"tag:team=db,volume-type", nil, true},
+		{" , ", nil, true},
+	}
+
+	for _, test := range tests {
+		filters, err := ParseVolumeFilter(test.filter)
+		if test.fails {
+			if err == nil {
+				t.Errorf("\"%s\": expected an error, got %v", test.filter, filters)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("\"%s\": %s", test.filter, err)
+			continue
+		}
+		parsed := make(map[string][]string)
+		for _, filter := range filters {
+			parsed[aws.StringValue(filter.Name)] = aws.StringValueSlice(filter.Values)
+		}
+		if len(filters) != len(parsed) || !reflect.DeepEqual(parsed, test.expected) {
+			t.Errorf("\"%s\": expected %v, got %v", test.filter, test.expected, filters)
+		}
+	}
+}